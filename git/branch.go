@@ -0,0 +1,135 @@
+// Abbreviation of branch names for display in a prompt.
+package git
+
+import "path"
+import "strings"
+import "unicode/utf8"
+
+// Rules for abbreviating a branch name for display. The zero value leaves
+// branch names unchanged.
+type BranchAbbrev struct {
+	// Prefixes to remove from branch names, such as "feature/". Only the first
+	// matching prefix is removed.
+	StripPrefixes []string
+	// Namespace patterns, such as "users/*". When the leading components of a
+	// branch name match one of these (using path.Match for each component),
+	// those components are shortened to their first letters, so that
+	// "users/alice/login" becomes "u/a/login".
+	ShortenNamespaces []string
+	// Maximum number of characters to show. Longer names have their middles
+	// replaced with an ellipsis. Zero means no limit.
+	MaxWidth int
+}
+
+// Abbreviates 'branch' according to these rules.
+func (self BranchAbbrev) Apply(branch string) string {
+	for _, prefix := range self.StripPrefixes {
+		if prefix != "" && strings.HasPrefix(branch, prefix) &&
+			len(branch) > len(prefix) {
+			branch = branch[len(prefix):]
+			break
+		}
+	}
+
+	var parts = strings.Split(branch, "/")
+	for _, pattern := range self.ShortenNamespaces {
+		var patternParts = strings.Split(strings.Trim(pattern, "/"), "/")
+		if matchNamespace(patternParts, parts) {
+			for i := range patternParts {
+				r, _ := utf8.DecodeRuneInString(parts[i])
+				parts[i] = string(r)
+			}
+			branch = strings.Join(parts, "/")
+			break
+		}
+	}
+
+	return truncateMiddle(branch, self.MaxWidth)
+}
+
+// True iff 'patternParts' match the leading components of 'parts', leaving at
+// least one component unmatched.
+func matchNamespace(patternParts []string, parts []string) bool {
+	if len(parts) <= len(patternParts) {
+		return false
+	}
+	for i, patternPart := range patternParts {
+		matched, err := path.Match(patternPart, parts[i])
+		if err != nil || !matched || parts[i] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Shortens 's' to at most 'width' characters by replacing its middle with an
+// ellipsis. A 'width' of zero means no limit.
+func truncateMiddle(s string, width int) string {
+	var runes = []rune(s)
+	if width <= 0 || len(runes) <= width {
+		return s
+	}
+	if width == 1 {
+		return "…"
+	}
+	// Favor the end of the name, which is usually the most distinctive part.
+	var head = (width - 1) / 2
+	var tail = width - 1 - head
+	return string(runes[:head]) + "…" + string(runes[len(runes)-tail:])
+}
+
+// Determines the default branch of a repo, on which we hide the branch name.
+// In order of preference, this is the repo's prompt.defaultBranch setting,
+// the branch that the upstream remote's HEAD points to, the branch HEAD
+// points to in a bare repo (whose HEAD names the branch clones check out),
+// the init.defaultBranch setting, or "master". 'config' is the repo's config
+// and 'commonDir' is its common Git dir.
+func defaultBranch(config Config, commonDir string, gitDir string) string {
+	if branch := config.Get("prompt.defaultBranch"); branch != "" {
+		return branch
+	}
+
+	// Try the remote the current branch tracks first, then origin.
+	var remotes []string
+	if branch := localBranch(gitDir); branch != "" {
+		var remote = config.Get("branch." + branch + ".remote")
+		if remote != "" && remote != "." {
+			remotes = append(remotes, remote)
+		}
+	}
+	remotes = append(remotes, "origin")
+	for _, remote := range remotes {
+		var prefix = "ref: refs/remotes/" + remote + "/"
+		var head = readFileString(
+			path.Join(commonDir, "refs", "remotes", remote, "HEAD"))
+		if strings.HasPrefix(head, prefix) && len(head) > len(prefix) {
+			return head[len(prefix):]
+		}
+	}
+
+	if bare, _ := config.GetBool("core.bare"); bare {
+		if branch := localBranch(gitDir); branch != "" {
+			return branch
+		}
+	}
+	if branch := config.Get("init.defaultBranch"); branch != "" {
+		return branch
+	}
+	return "master"
+}
+
+// Returns the short name of the upstream of local branch 'branch', as
+// configured in 'config' (e.g. "origin/master", or "master" for a local
+// upstream). Returns "" if it has none.
+func upstreamBranch(config Config, branch string) string {
+	var remote = config.Get("branch." + branch + ".remote")
+	var merge = config.Get("branch." + branch + ".merge")
+	if remote == "" || !strings.HasPrefix(merge, "refs/heads/") {
+		return ""
+	}
+	merge = strings.TrimPrefix(merge, "refs/heads/")
+	if remote == "." {
+		return merge
+	}
+	return remote + "/" + merge
+}
@@ -0,0 +1,112 @@
+package git
+
+import "os"
+import "testing"
+
+func TestBranchAbbrev(t *testing.T) {
+	var abbrev = BranchAbbrev{
+		StripPrefixes:     []string{"feature/", "bugfix/"},
+		ShortenNamespaces: []string{"users/*", "sethpollen"},
+	}
+	var cases = []struct {
+		branch   string
+		expected string
+	}{
+		{"master", "master"},
+		{"feature/login", "login"},
+		{"bugfix/login", "login"},
+		{"feature/", "feature/"},
+		{"users/alice/login", "u/a/login"},
+		{"users/alice", "users/alice"},
+		{"sethpollen/feature/x", "s/feature/x"},
+		{"other/login", "other/login"},
+	}
+	for _, c := range cases {
+		var actual = abbrev.Apply(c.branch)
+		if actual != c.expected {
+			t.Errorf("%s: expected \"%s\", got \"%s\"", c.branch, c.expected,
+				actual)
+		}
+	}
+}
+
+func TestBranchAbbrevZeroValue(t *testing.T) {
+	var actual = BranchAbbrev{}.Apply("feature/some-very-long-branch-name")
+	if actual != "feature/some-very-long-branch-name" {
+		t.Errorf("Got \"%s\"", actual)
+	}
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	var cases = []struct {
+		s        string
+		width    int
+		expected string
+	}{
+		{"abcdef", 0, "abcdef"},
+		{"abcdef", 6, "abcdef"},
+		{"abcdef", 5, "ab…ef"},
+		{"abcdefg", 4, "a…fg"},
+		{"日本語日本語", 3, "日…語"},
+		{"abcdef", 1, "…"},
+	}
+	for _, c := range cases {
+		var actual = truncateMiddle(c.s, c.width)
+		if actual != c.expected {
+			t.Errorf("%s/%d: expected \"%s\", got \"%s\"", c.s, c.width,
+				c.expected, actual)
+		}
+	}
+}
+
+func TestDefaultBranch(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"HEAD":                      "ref: refs/heads/feature\n",
+		"refs/remotes/origin/HEAD":  "ref: refs/remotes/origin/main\n",
+		"refs/remotes/fork/HEAD":    "ref: refs/remotes/fork/trunk\n",
+		"refs/remotes/origin/other": "0123\n",
+	})
+	defer os.RemoveAll(dir)
+
+	var cases = []struct {
+		config   string
+		expected string
+	}{
+		{"[prompt]\ndefaultBranch = dev\n[init]\ndefaultBranch = x\n", "dev"},
+		{"", "main"},
+		{"[branch \"feature\"]\nremote = fork\n", "trunk"},
+	}
+	for _, c := range cases {
+		var actual = defaultBranch(parseConfig(c.config), dir, dir)
+		if actual != c.expected {
+			t.Errorf("Expected \"%s\", got \"%s\"", c.expected, actual)
+		}
+	}
+
+	var empty = makeGitDir(t, map[string]string{})
+	defer os.RemoveAll(empty)
+	var actual = defaultBranch(parseConfig("[init]\ndefaultBranch = x\n"),
+		empty, empty)
+	if actual != "x" {
+		t.Errorf("Expected \"x\", got \"%s\"", actual)
+	}
+	actual = defaultBranch(parseConfig(""), empty, empty)
+	if actual != "master" {
+		t.Errorf("Expected \"master\", got \"%s\"", actual)
+	}
+}
+
+func TestDefaultBranchBare(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{"HEAD": "ref: refs/heads/main\n"})
+	defer os.RemoveAll(dir)
+	var config = "[core]\nbare = true\n[init]\ndefaultBranch = x\n"
+	var actual = defaultBranch(parseConfig(config), dir, dir)
+	if actual != "main" {
+		t.Errorf("Expected \"main\", got \"%s\"", actual)
+	}
+	// In a non-bare repo, HEAD is just whatever is checked out.
+	actual = defaultBranch(parseConfig("[init]\ndefaultBranch = x\n"), dir, dir)
+	if actual != "x" {
+		t.Errorf("Expected \"x\", got \"%s\"", actual)
+	}
+}
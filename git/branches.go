@@ -0,0 +1,153 @@
+// Listing of all local branches, to keep track of unpushed work.
+package git
+
+import "crypto/sha1"
+import "errors"
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+import "github.com/bradfitz/gomemcache/memcache"
+
+// A local branch and how it relates to its upstream.
+type BranchInfo struct {
+	// Name of the branch, without "refs/heads/".
+	Name string
+	// True iff this branch is checked out in the current worktree.
+	Current bool
+	// Name of the upstream ref which the branch tracks (e.g. "origin/master"),
+	// or "" if there is none.
+	Upstream string
+	// True iff the branch tracks an upstream ref which no longer exists.
+	UpstreamGone bool
+	// Number of commits on the branch which have not been pushed to Upstream,
+	// and vice versa.
+	Ahead  int
+	Behind int
+	// Committer date of the commit at the tip of the branch.
+	CommitTime time.Time
+}
+
+// The fields we ask git for-each-ref for, separated by NULs.
+const branchListFormat = "%(HEAD)%00%(refname)%00%(upstream:short)%00" +
+	"%(upstream:track,nobracket)%00%(committerdate:unix)"
+
+// Lists every local branch in the repo containing 'pwd', sorted by name.
+// Computing ahead/behind counts can be slow in repos with many branches.
+func ListBranches(pwd string) ([]BranchInfo, error) {
+	if repoPath, gitDir, err := findWorkTree(pwd); err == nil &&
+		isForeignRepo(repoPath, gitDir) {
+		return nil, errors.New("Repo is owned by another user: " + repoPath)
+	}
+	output, err := runGit(pwd, 0, "for-each-ref",
+		"--format="+branchListFormat, "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	return parseBranchList(output), nil
+}
+
+// Parses the output of git for-each-ref with branchListFormat. Malformed
+// lines are skipped.
+func parseBranchList(output string) []BranchInfo {
+	var branches []BranchInfo
+	for _, line := range strings.Split(output, "\n") {
+		var fields = strings.Split(line, "\x00")
+		if len(fields) != 5 {
+			continue
+		}
+		var branch BranchInfo
+		branch.Current = fields[0] == "*"
+		branch.Name = strings.TrimPrefix(fields[1], "refs/heads/")
+		branch.Upstream = fields[2]
+		branch.Ahead, branch.Behind, branch.UpstreamGone = parseTrack(fields[3])
+		if seconds, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
+			branch.CommitTime = time.Unix(seconds, 0)
+		}
+		branches = append(branches, branch)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
+	return branches
+}
+
+// Parses an %(upstream:track,nobracket) value, such as "ahead 1, behind 2"
+// or "gone".
+func parseTrack(track string) (ahead int, behind int, gone bool) {
+	if track == "gone" {
+		return 0, 0, true
+	}
+	for _, part := range strings.Split(track, ", ") {
+		var words = strings.Fields(part)
+		if len(words) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(words[1])
+		if err != nil {
+			continue
+		}
+		switch words[0] {
+		case "ahead":
+			ahead = n
+		case "behind":
+			behind = n
+		}
+	}
+	return ahead, behind, false
+}
+
+// Returns the names of the branches in 'branches' which have commits that
+// have not been pushed to their upstream. Branches without an upstream are
+// not included, since we can't tell what has been pushed.
+func unpushedBranches(branches []BranchInfo) []string {
+	var names []string
+	for _, branch := range branches {
+		if branch.Ahead > 0 {
+			names = append(names, branch.Name)
+		}
+	}
+	return names
+}
+
+// How long to keep lists of unpushed branches in memcache. They are only
+// refreshed by the --update_cache pass, so this should outlast the gaps
+// between those.
+const unpushedCacheSeconds = 24 * 60 * 60
+
+// Returns the memcache key for the unpushed branches of the repo rooted at
+// 'repoPath'.
+func unpushedCacheKey(repoPath string) string {
+	return fmt.Sprintf("git-unpushed:%x", sha1.Sum([]byte(repoPath)))
+}
+
+// Stores 'names' as the unpushed branches of the repo rooted at 'repoPath'.
+func storeUnpushedBranches(mc *memcache.Client, repoPath string,
+	names []string) {
+	mc.Set(&memcache.Item{
+		Key:        unpushedCacheKey(repoPath),
+		Value:      []byte(strings.Join(names, "\n")),
+		Expiration: unpushedCacheSeconds,
+	})
+}
+
+// Looks up the unpushed branches of the repo rooted at 'repoPath', as stored
+// by storeUnpushedBranches. Returns nil if nothing is stored.
+func loadUnpushedBranches(mc *memcache.Client, repoPath string) []string {
+	item, err := mc.Get(unpushedCacheKey(repoPath))
+	if err != nil || len(item.Value) == 0 {
+		return nil
+	}
+	return strings.Split(string(item.Value), "\n")
+}
+
+// True iff 'names' contains a branch other than 'current'.
+func hasOtherBranch(names []string, current string) bool {
+	for _, name := range names {
+		if name != current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package git
+
+import "testing"
+import "time"
+
+func TestParseBranchList(t *testing.T) {
+	var output = " \x00refs/heads/zeta\x00origin/zeta\x00" +
+		"ahead 2, behind 1\x0010\n" +
+		"*\x00refs/heads/master\x00origin/master\x00\x0020\n" +
+		" \x00refs/heads/old\x00origin/old\x00gone\x0030\n" +
+		" \x00refs/heads/local\x00\x00\x0040\n" +
+		"garbage"
+	var branches = parseBranchList(output)
+	var expected = []BranchInfo{
+		{Name: "local", CommitTime: time.Unix(40, 0)},
+		{Name: "master", Current: true, Upstream: "origin/master",
+			CommitTime: time.Unix(20, 0)},
+		{Name: "old", Upstream: "origin/old", UpstreamGone: true,
+			CommitTime: time.Unix(30, 0)},
+		{Name: "zeta", Upstream: "origin/zeta", Ahead: 2, Behind: 1,
+			CommitTime: time.Unix(10, 0)},
+	}
+	if len(branches) != len(expected) {
+		t.Fatalf("Expected %d branches, got %v", len(expected), branches)
+	}
+	for i := range expected {
+		if branches[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], branches[i])
+		}
+	}
+}
+
+func TestParseTrack(t *testing.T) {
+	var cases = []struct {
+		track         string
+		ahead, behind int
+		gone          bool
+	}{
+		{"", 0, 0, false},
+		{"ahead 3", 3, 0, false},
+		{"behind 4", 0, 4, false},
+		{"ahead 1, behind 2", 1, 2, false},
+		{"gone", 0, 0, true},
+	}
+	for _, c := range cases {
+		ahead, behind, gone := parseTrack(c.track)
+		if ahead != c.ahead || behind != c.behind || gone != c.gone {
+			t.Errorf("%q: got %d %d %v", c.track, ahead, behind, gone)
+		}
+	}
+}
+
+func TestUnpushedBranches(t *testing.T) {
+	var names = unpushedBranches([]BranchInfo{
+		{Name: "a", Ahead: 1},
+		{Name: "b", Behind: 1},
+		{Name: "c"},
+		{Name: "d", Ahead: 2, Behind: 2},
+	})
+	if len(names) != 2 || names[0] != "a" || names[1] != "d" {
+		t.Errorf("Expected [a d], got %v", names)
+	}
+	if !hasOtherBranch(names, "a") {
+		t.Errorf("Expected d to count as another branch")
+	}
+	if hasOtherBranch([]string{"a"}, "a") || hasOtherBranch(nil, "a") {
+		t.Errorf("Expected no other branches")
+	}
+}
@@ -0,0 +1,105 @@
+// Caching of whole GitInfos in memcache.
+package git
+
+import "bytes"
+import "crypto/sha1"
+import "encoding/json"
+import "fmt"
+import "os"
+import "path"
+import "github.com/bradfitz/gomemcache/memcache"
+
+// Identifies the format of cached GitInfos. Bump this whenever GitInfo changes
+// in a way which would make old cache entries decode incorrectly; entries
+// with any other version are ignored.
+const infoCacheVersion = "gitinfo10"
+
+// How long to keep cached GitInfos in memcache. The stamp check makes most
+// entries obsolete long before this.
+const infoCacheSeconds = 24 * 60 * 60
+
+// Returns the memcache key for the GitInfo of the repo rooted at 'repoPath'.
+// 'subtree' is the subtree to which git status was scoped, or "".
+func infoCacheKey(repoPath string, subtree string) string {
+	var id = repoPath
+	if subtree != "" {
+		id += "\x00" + subtree
+	}
+	return fmt.Sprintf("git-info:%x", sha1.Sum([]byte(id)))
+}
+
+// Builds a string which changes whenever the index or HEAD of the repo with
+// Git dir 'gitDir' changes, or the repo is fetched. 'commonDir' is its common
+// Git dir. A cached GitInfo is only valid while the stamp it was stored with
+// still matches.
+func repoStamp(gitDir string, commonDir string) string {
+	var stamp bytes.Buffer
+	// logs/HEAD changes whenever HEAD moves, including when a commit moves the
+	// current branch without touching HEAD itself. FETCH_HEAD changes with
+	// every fetch, which affects LastFetch and the upstream counts.
+	for _, p := range []string{
+		path.Join(gitDir, "index"),
+		path.Join(gitDir, "HEAD"),
+		path.Join(gitDir, "logs/HEAD"),
+		path.Join(commonDir, "FETCH_HEAD"),
+	} {
+		var mtime int64
+		fileInfo, err := os.Stat(p)
+		if err == nil {
+			mtime = fileInfo.ModTime().UnixNano()
+		}
+		fmt.Fprintf(&stamp, "%d ", mtime)
+	}
+	return stamp.String()
+}
+
+// Serializes 'info' for storage in memcache, along with 'stamp'.
+func encodeCachedInfo(info *GitInfo, stamp string) ([]byte, error) {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n%s\n", infoCacheVersion, stamp)
+	buf.Write(encoded)
+	return buf.Bytes(), nil
+}
+
+// Inverse of encodeCachedInfo. Returns nil if 'data' has the wrong version or
+// was not stored with 'stamp'.
+func decodeCachedInfo(data []byte, stamp string) *GitInfo {
+	var parts = bytes.SplitN(data, []byte("\n"), 3)
+	if len(parts) != 3 || string(parts[0]) != infoCacheVersion ||
+		string(parts[1]) != stamp {
+		return nil
+	}
+	var info = new(GitInfo)
+	if err := json.Unmarshal(parts[2], info); err != nil {
+		return nil
+	}
+	return info
+}
+
+// Looks up the cached GitInfo under 'key', as returned by infoCacheKey.
+// Returns nil if there is no usable entry.
+func loadCachedInfo(mc *memcache.Client, key string, stamp string) *GitInfo {
+	item, err := mc.Get(key)
+	if err != nil {
+		return nil
+	}
+	return decodeCachedInfo(item.Value, stamp)
+}
+
+// Stores 'info' as the cached GitInfo under 'key'.
+func storeCachedInfo(mc *memcache.Client, key string, stamp string,
+	info *GitInfo) {
+	data, err := encodeCachedInfo(info, stamp)
+	if err != nil {
+		return
+	}
+	mc.Set(&memcache.Item{
+		Key:        key,
+		Value:      data,
+		Expiration: infoCacheSeconds,
+	})
+}
@@ -0,0 +1,75 @@
+package git
+
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+import "time"
+
+func TestCachedInfoRoundTrip(t *testing.T) {
+	var info = &GitInfo{
+		RepoName:     "r",
+		Branch:       "b",
+		Ahead:        2,
+		StatusCounts: StatusCounts{Modified: 3},
+		Operation:    OpMerge,
+		Worktrees:    []Worktree{{Name: "w", Path: "/w", Branch: "c"}},
+	}
+	data, err := encodeCachedInfo(info, "1 2 3 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded = decodeCachedInfo(data, "1 2 3 ")
+	if decoded == nil {
+		t.Fatal("Expected a GitInfo")
+	}
+	if decoded.String() != info.String() {
+		t.Errorf("Expected \"%s\", got \"%s\"", info.String(), decoded.String())
+	}
+	if len(decoded.Worktrees) != 1 || decoded.Worktrees[0] != info.Worktrees[0] {
+		t.Errorf("Expected %+v, got %+v", info.Worktrees, decoded.Worktrees)
+	}
+}
+
+func TestCachedInfoStaleStamp(t *testing.T) {
+	data, err := encodeCachedInfo(&GitInfo{RepoName: "r"}, "1 2 3 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodeCachedInfo(data, "1 2 4 ") != nil {
+		t.Error("Expected nil for a stale stamp")
+	}
+}
+
+func TestCachedInfoOldVersion(t *testing.T) {
+	var data = []byte("gitinfo0\n1 2 3 \n{\"RepoName\":\"r\"}")
+	if decodeCachedInfo(data, "1 2 3 ") != nil {
+		t.Error("Expected nil for an old version")
+	}
+	if decodeCachedInfo([]byte("garbage"), "1 2 3 ") != nil {
+		t.Error("Expected nil for garbage")
+	}
+}
+
+func TestInfoCacheKeySubtree(t *testing.T) {
+	var whole = infoCacheKey("/src/mono", "")
+	var scoped = infoCacheKey("/src/mono", "services/auth")
+	if whole == scoped {
+		t.Errorf("Expected distinct keys for scoped and unscoped results")
+	}
+	if scoped == infoCacheKey("/src/mono", "services/billing") {
+		t.Errorf("Expected distinct keys for different subtrees")
+	}
+}
+
+func TestRepoStampChangesOnFetch(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{"HEAD": "ref: refs/heads/b\n"})
+	defer os.RemoveAll(dir)
+	var before = repoStamp(dir, dir)
+	var fetched = time.Now().Add(-time.Minute)
+	ioutil.WriteFile(path.Join(dir, "FETCH_HEAD"), []byte("0123\n"), 0644)
+	os.Chtimes(path.Join(dir, "FETCH_HEAD"), fetched, fetched)
+	if repoStamp(dir, dir) == before {
+		t.Errorf("Expected a fetch to change the stamp")
+	}
+}
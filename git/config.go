@@ -0,0 +1,370 @@
+// A native parser for Git config files, so that we can read settings without
+// spawning git.
+package git
+
+import "bufio"
+import "io/ioutil"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+import "time"
+
+// The settings from one or more Git config files. Keys are of the form
+// "section.key" or "section.subsection.key", with the section and key names
+// lowercased (subsection names are case-sensitive). Each key maps to all of
+// its values, in the order they appeared.
+type Config map[string][]string
+
+// Normalizes a config key by lowercasing its section and variable names.
+func normalizeConfigKey(key string) string {
+	var first = strings.Index(key, ".")
+	var last = strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] +
+		strings.ToLower(key[last:])
+}
+
+// Returns the last value for 'key', or "" if it is not set.
+func (self Config) Get(key string) string {
+	var values = self[normalizeConfigKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// Returns all values for 'key'.
+func (self Config) GetAll(key string) []string {
+	return self[normalizeConfigKey(key)]
+}
+
+// Interprets the last value for 'key' as a boolean. The second return value
+// is false if 'key' is not set to a recognizable boolean.
+func (self Config) GetBool(key string) (bool, bool) {
+	switch strings.ToLower(self.Get(key)) {
+	case "true", "yes", "on", "1":
+		return true, true
+	case "false", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// Appends the settings from 'other' to this Config. Settings from 'other'
+// take precedence in Get.
+func (self Config) Merge(other Config) {
+	for key, values := range other {
+		self[key] = append(self[key], values...)
+	}
+}
+
+// Reads and parses the config file at 'p'. Include directives are not
+// followed.
+func ReadConfig(p string) (Config, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(string(data)), nil
+}
+
+// Reads the settings which apply to the repo whose Git dirs are 'gitDir' and
+// 'commonDir': the user's global config, followed by the repo's own config.
+// Files which can't be read are skipped.
+func readRepoConfig(gitDir string, commonDir string) Config {
+	var config = readGlobalConfig()
+	config.Merge(readLocalConfig(gitDir, commonDir))
+	return config
+}
+
+// Reads the repo's own config (as opposed to the user's) for the repo whose
+// Git dirs are 'gitDir' and 'commonDir', followed by the per-worktree config
+// if the repo has enabled it. These files are under the repo's control.
+// Conditional includes are followed whatever their conditions, so the result
+// may contain more settings than Git would apply.
+func readLocalConfig(gitDir string, commonDir string) Config {
+	var config = make(Config)
+	var p = path.Join(commonDir, "config")
+	if local, err := readConfigIncludes(p, true, 0); err == nil {
+		config.Merge(local)
+	}
+	var worktreeConfig, _ = config.GetBool("extensions.worktreeConfig")
+	if worktreeConfig {
+		p = path.Join(gitDir, "config.worktree")
+		if worktree, err := readConfigIncludes(p, true, 0); err == nil {
+			config.Merge(worktree)
+		}
+	}
+	return config
+}
+
+// Reads the config files which Git trusts wherever it runs: the system config
+// followed by the user's global config, with their include directives. These
+// are the only files Git reads safe.directory from. Conditional includes
+// ([includeIf]) are not followed; when checking safe.directory, Git has no
+// repo to match their conditions against either. Files which can't be read
+// are skipped.
+func readGlobalConfig() Config {
+	var config = make(Config)
+	var paths = globalConfigPaths()
+	if system := systemConfigPath(); system != "" {
+		paths = append([]string{system}, paths...)
+	}
+	for _, p := range paths {
+		if global, err := readConfigIncludes(p, false, 0); err == nil {
+			config.Merge(global)
+		}
+	}
+	return config
+}
+
+// Returns the path of the system config file, or "" if Git has been told not
+// to read it.
+func systemConfigPath() string {
+	var noSystem, _ = strconv.ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM"))
+	if noSystem {
+		return ""
+	}
+	if p := os.Getenv("GIT_CONFIG_SYSTEM"); p != "" {
+		return p
+	}
+	return "/etc/gitconfig"
+}
+
+// Returns the paths of the user's global config files, in the order Git
+// reads them. GIT_CONFIG_GLOBAL replaces them all.
+func globalConfigPaths() []string {
+	if p := os.Getenv("GIT_CONFIG_GLOBAL"); p != "" {
+		return []string{p}
+	}
+	var paths []string
+	var xdgHome = os.Getenv("XDG_CONFIG_HOME")
+	var home = os.Getenv("HOME")
+	if xdgHome == "" && home != "" {
+		xdgHome = path.Join(home, ".config")
+	}
+	if xdgHome != "" {
+		paths = append(paths, path.Join(xdgHome, "git", "config"))
+	}
+	if home != "" {
+		paths = append(paths, path.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// Git gives up on include chains deeper than this.
+const maxConfigIncludeDepth = 10
+
+// Reads and parses the config file at 'p', which is 'depth' includes deep,
+// along with the files it includes. Included settings take effect where the
+// include directive appears, as in Git. If 'conditional' is set, [includeIf]
+// files are also read, regardless of their conditions. Included files which
+// can't be read are skipped.
+func readConfigIncludes(p string, conditional bool,
+	depth int) (Config, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var include = func(key string, value string) Config {
+		var isInclude = key == "include.path" ||
+			(conditional && strings.HasPrefix(key, "includeif.") &&
+				strings.HasSuffix(key, ".path"))
+		if !isInclude || value == "" || depth >= maxConfigIncludeDepth {
+			return nil
+		}
+		var included = expandIncludePath(value, path.Dir(p))
+		config, _ := readConfigIncludes(included, conditional, depth+1)
+		return config
+	}
+	return parseConfigIncludes(string(data), include), nil
+}
+
+// Resolves the path in an include directive, which may start with "~/" or be
+// relative to 'dir', the directory of the including file.
+func expandIncludePath(value string, dir string) string {
+	if strings.HasPrefix(value, "~/") {
+		return path.Join(os.Getenv("HOME"), value[2:])
+	}
+	if !path.IsAbs(value) {
+		return path.Join(dir, value)
+	}
+	return value
+}
+
+// Parses the text of a Git config file. Malformed lines are skipped.
+func parseConfig(text string) Config {
+	return parseConfigIncludes(text, nil)
+}
+
+// Like parseConfig, but calls 'include' (if not nil) with each setting, and
+// merges the Config it returns (if any) in at that point.
+func parseConfigIncludes(text string,
+	include func(key string, value string) Config) Config {
+	var config = make(Config)
+	var section = ""
+	var scanner = bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		var line = scanner.Text()
+		// A trailing backslash continues the line.
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			line = line[:len(line)-1] + scanner.Text()
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			var end = strings.LastIndex(line, "]")
+			if end < 0 {
+				continue
+			}
+			section = parseConfigSection(line[1:end])
+			// A variable may follow the section header on the same line.
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+		if section == "" {
+			continue
+		}
+
+		var name = line
+		var value = "true"
+		if eq := strings.Index(line, "="); eq >= 0 {
+			name = line[:eq]
+			value = parseConfigValue(line[eq+1:])
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		var key = section + "." + name
+		config[key] = append(config[key], value)
+		if include != nil {
+			if included := include(key, value); included != nil {
+				config.Merge(included)
+			}
+		}
+	}
+	return config
+}
+
+// Parses the inside of a section header, such as `remote "origin"`, into the
+// prefix used for its keys (e.g. "remote.origin").
+func parseConfigSection(header string) string {
+	header = strings.TrimSpace(header)
+	var quote = strings.Index(header, "\"")
+	if quote < 0 {
+		// Either a plain section or the deprecated [section.subsection] syntax,
+		// in which the subsection is case-insensitive.
+		return strings.ToLower(header)
+	}
+	var name = strings.ToLower(strings.TrimSpace(header[:quote]))
+	var subsection = strings.TrimSuffix(header[quote+1:], "\"")
+	subsection = strings.NewReplacer("\\\"", "\"", "\\\\", "\\").
+		Replace(subsection)
+	return name + "." + subsection
+}
+
+// Parses the right-hand side of a "name = value" line, handling quotes,
+// escapes and trailing comments.
+func parseConfigValue(raw string) string {
+	var value []rune
+	var inQuotes = false
+	var escaped = false
+	// Whitespace outside of quotes is only kept if something follows it.
+	var pendingSpace []rune
+	for _, c := range raw {
+		if escaped {
+			switch c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			}
+			value = append(value, pendingSpace...)
+			value = append(value, c)
+			pendingSpace = nil
+			escaped = false
+			continue
+		}
+		switch {
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && (c == '#' || c == ';'):
+			return string(value)
+		case !inQuotes && (c == ' ' || c == '\t'):
+			if len(value) > 0 {
+				pendingSpace = append(pendingSpace, c)
+			}
+		default:
+			value = append(value, pendingSpace...)
+			value = append(value, c)
+			pendingSpace = nil
+		}
+	}
+	return string(value)
+}
+
+// Returns a copy of these Options with the query settings from the [prompt]
+// section of 'config' applied. 'repoPath' is the root of the repo 'config'
+// belongs to.
+func (self Options) withRepoConfig(config Config, repoPath string) Options {
+	var timeout, ok = parseConfigDuration(config.Get("prompt.statusTimeout"))
+	// A zero timeout means the caller can afford to wait, so leave it.
+	if ok && self.StatusTimeout > 0 {
+		self.StatusTimeout = timeout
+	}
+	if untracked := config.Get("prompt.untracked"); untracked != "" {
+		self.Untracked = ParseUntrackedMode(untracked)
+	}
+	var subtreeRepos []string
+	for _, repo := range self.SubtreeRepos {
+		if path.Clean(repo) != repoPath {
+			subtreeRepos = append(subtreeRepos, repo)
+		}
+	}
+	switch strings.ToLower(config.Get("prompt.dirtyScope")) {
+	case "subtree":
+		self.SubtreeRepos = append(subtreeRepos, repoPath)
+	case "repo":
+		self.SubtreeRepos = subtreeRepos
+	}
+	return self
+}
+
+// Applies the display settings from the [prompt] section of 'config' to
+// 'info'.
+func (info *GitInfo) applyRepoConfig(config Config) {
+	if name := config.Get("prompt.name"); name != "" {
+		info.RepoName = name
+	}
+	info.HiddenBranches = nil
+	for _, value := range config.GetAll("prompt.hideBranch") {
+		info.HiddenBranches = append(info.HiddenBranches, splitFlag(value)...)
+	}
+}
+
+// Parses a duration from a config value, such as "300ms" or "2s". A plain
+// number is taken as milliseconds. The second return value is false if 'value'
+// is empty or malformed.
+func parseConfigDuration(value string) (time.Duration, bool) {
+	if millis, err := strconv.Atoi(value); err == nil {
+		return time.Duration(millis) * time.Millisecond, true
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return duration, true
+}
@@ -0,0 +1,230 @@
+package git
+
+import "os"
+import "path"
+import "testing"
+import "time"
+
+func TestParseConfig(t *testing.T) {
+	var config = parseConfig(`
+# A comment.
+[core]
+	bare = false
+	Editor = "vim -u NONE" ; trailing comment
+[remote "origin"]
+	url = git@github.com:sethpollen/sbp-go-utils.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[Remote "Upstream"]
+	url = https://example.com/a/b
+[url "git@github.com:"]
+	insteadOf = gh:
+	insteadOf = github:
+[branch.Feature]
+	remote = origin
+[prompt]
+	flag
+	name = with \"quotes\" and \\ backslash \
+continued
+`)
+	var cases = []struct {
+		key      string
+		expected string
+	}{
+		{"core.bare", "false"},
+		{"core.editor", "vim -u NONE"},
+		{"CORE.EDITOR", "vim -u NONE"},
+		{"remote.origin.url", "git@github.com:sethpollen/sbp-go-utils.git"},
+		{"remote.Upstream.url", "https://example.com/a/b"},
+		{"remote.upstream.url", ""},
+		{"url.git@github.com:.insteadof", "github:"},
+		{"branch.feature.remote", "origin"},
+		{"prompt.flag", "true"},
+		{"prompt.name", "with \"quotes\" and \\ backslash continued"},
+		{"no.such.key", ""},
+	}
+	for _, c := range cases {
+		var actual = config.Get(c.key)
+		if actual != c.expected {
+			t.Errorf("%s: expected \"%s\", got \"%s\"", c.key, c.expected, actual)
+		}
+	}
+	if len(config.GetAll("url.git@github.com:.insteadOf")) != 2 {
+		t.Errorf("Expected 2 values, got %v",
+			config.GetAll("url.git@github.com:.insteadOf"))
+	}
+}
+
+func TestConfigGetBool(t *testing.T) {
+	var config = parseConfig("[a]\nx = yes\ny = Off\nz = maybe\nw\n")
+	var cases = []struct {
+		key   string
+		value bool
+		ok    bool
+	}{
+		{"a.x", true, true},
+		{"a.y", false, true},
+		{"a.z", false, false},
+		{"a.w", true, true},
+		{"a.missing", false, false},
+	}
+	for _, c := range cases {
+		value, ok := config.GetBool(c.key)
+		if value != c.value || ok != c.ok {
+			t.Errorf("%s: expected (%v, %v), got (%v, %v)",
+				c.key, c.value, c.ok, value, ok)
+		}
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	var config = parseConfig("[a]\nx = 1\n")
+	config.Merge(parseConfig("[a]\nx = 2\n"))
+	if config.Get("a.x") != "2" {
+		t.Errorf("Expected \"2\", got \"%s\"", config.Get("a.x"))
+	}
+	if len(config.GetAll("a.x")) != 2 {
+		t.Errorf("Expected 2 values, got %v", config.GetAll("a.x"))
+	}
+}
+
+func TestReadRepoConfigWorktree(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"common/config": "[extensions]\n\tworktreeConfig = true\n" +
+			"[core]\n\tsparseCheckout = false\n",
+		"wt/config.worktree": "[core]\n\tsparseCheckout = true\n",
+	})
+	defer os.RemoveAll(dir)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("HOME", dir)
+	os.Setenv("XDG_CONFIG_HOME", dir)
+
+	var config = readRepoConfig(path.Join(dir, "wt"), path.Join(dir, "common"))
+	if config.Get("core.sparseCheckout") != "true" {
+		t.Errorf("Expected \"true\", got \"%s\"",
+			config.Get("core.sparseCheckout"))
+	}
+}
+
+func TestReadGlobalConfig(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"system": "[a]\n\tx = system\n\ty = system\n",
+		"global": "[a]\n\tx = before\n[include]\n\tpath = sub/included\n" +
+			"[includeIf \"gitdir:/\"]\n\tpath = conditional\n" +
+			"[a]\n\tz = after\n",
+		"sub/included": "[a]\n\tx = included\n\tz = included\n" +
+			"[include]\n\tpath = ../loop\n",
+		"loop":        "[include]\n\tpath = sub/included\n",
+		"conditional": "[a]\n\tx = conditional\n",
+	})
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"GIT_CONFIG_SYSTEM", "GIT_CONFIG_GLOBAL",
+		"GIT_CONFIG_NOSYSTEM"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("GIT_CONFIG_SYSTEM", path.Join(dir, "system"))
+	os.Setenv("GIT_CONFIG_GLOBAL", path.Join(dir, "global"))
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "")
+
+	var config = readGlobalConfig()
+	var cases = []struct {
+		key      string
+		expected string
+	}{
+		{"a.x", "included"},
+		{"a.y", "system"},
+		{"a.z", "after"},
+	}
+	for _, c := range cases {
+		var actual = config.Get(c.key)
+		if actual != c.expected {
+			t.Errorf("%s: expected \"%s\", got \"%s\"", c.key, c.expected, actual)
+		}
+	}
+
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	config = readGlobalConfig()
+	if config.Get("a.y") != "" {
+		t.Errorf("Expected no system config, got \"%s\"", config.Get("a.y"))
+	}
+}
+
+func TestReadLocalConfigConditionalIncludes(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"config": "[includeIf \"onbranch:nope\"]\n\tpath = extra\n",
+		"extra":  "[filter \"x\"]\n\tclean = cat\n",
+	})
+	defer os.RemoveAll(dir)
+	var config = readLocalConfig(dir, dir)
+	if config.Get("filter.x.clean") != "cat" {
+		t.Errorf("Expected \"cat\", got \"%s\"", config.Get("filter.x.clean"))
+	}
+}
+
+func TestWithRepoConfig(t *testing.T) {
+	var config = parseConfig("[prompt]\n" +
+		"\tstatusTimeout = 2s\n" +
+		"\tuntracked = no\n" +
+		"\tdirtyScope = subtree\n")
+	var opts = Options{StatusTimeout: time.Second}.
+		withRepoConfig(config, "/src/mono")
+	if opts.StatusTimeout != 2*time.Second {
+		t.Errorf("Expected 2s, got %v", opts.StatusTimeout)
+	}
+	if opts.Untracked != UntrackedNo {
+		t.Errorf("Expected UntrackedNo, got %v", opts.Untracked)
+	}
+	if len(opts.SubtreeRepos) != 1 || opts.SubtreeRepos[0] != "/src/mono" {
+		t.Errorf("Expected [/src/mono], got %v", opts.SubtreeRepos)
+	}
+
+	// The background pass keeps its unlimited timeout.
+	opts = Options{}.withRepoConfig(config, "/src/mono")
+	if opts.StatusTimeout != 0 {
+		t.Errorf("Expected no timeout, got %v", opts.StatusTimeout)
+	}
+
+	config = parseConfig("[prompt]\n\tdirtyScope = repo\n")
+	opts = Options{SubtreeRepos: []string{"/src/mono/", "/src/other"}}.
+		withRepoConfig(config, "/src/mono")
+	if len(opts.SubtreeRepos) != 1 || opts.SubtreeRepos[0] != "/src/other" {
+		t.Errorf("Expected [/src/other], got %v", opts.SubtreeRepos)
+	}
+}
+
+func TestApplyRepoConfig(t *testing.T) {
+	var config = parseConfig("[prompt]\n" +
+		"\tname = web\n" +
+		"\thideBranch = develop, trunk\n" +
+		"\thideBranch = release\n")
+	var info = GitInfo{RepoName: "r", Branch: "trunk", DefaultBranch: "main"}
+	info.applyRepoConfig(config)
+	if info.String() != "web" {
+		t.Errorf("Expected \"web\", got \"%s\"", info.String())
+	}
+	info.Branch = "feature"
+	if info.String() != "web: feature" {
+		t.Errorf("Expected \"web: feature\", got \"%s\"", info.String())
+	}
+}
+
+func TestParseConfigDuration(t *testing.T) {
+	var cases = []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"300ms", 300 * time.Millisecond, true},
+		{"1.5s", 1500 * time.Millisecond, true},
+		{"250", 250 * time.Millisecond, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, c := range cases {
+		actual, ok := parseConfigDuration(c.value)
+		if actual != c.expected || ok != c.ok {
+			t.Errorf("%q: expected %v %v, got %v %v", c.value, c.expected, c.ok,
+				actual, ok)
+		}
+	}
+}
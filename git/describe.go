@@ -0,0 +1,99 @@
+// Support for "git describe"-style version info, cached in memcache.
+package git
+
+import "crypto/sha1"
+import "fmt"
+import "regexp"
+import "strconv"
+import "strings"
+import "github.com/bradfitz/gomemcache/memcache"
+import "github.com/sethpollen/sbp-go-utils/util"
+
+// How long to keep describe results in memcache. Describe results for a given
+// commit only change when tags are added or removed, so this can be long.
+const describeCacheSeconds = 60 * 60
+
+// Regex to match the output of git describe --long. The submatches are the
+// tag and the number of commits since the tag.
+var describeRegex = regexp.MustCompile("^(.+)-([0-9]+)-g[0-9a-f]+$")
+
+// Information about the tag nearest to HEAD.
+type describeResult struct {
+	// The nearest tag reachable from HEAD, or "" if there is none.
+	Tag string
+	// Number of commits between Tag and HEAD.
+	Distance int
+}
+
+// Finds the nearest tag reachable from 'commit', using 'mc' to cache the
+// result. 'commonDir' identifies the repo. 'mc' may be nil, in which case
+// nothing is cached. Returns util.ErrTimeout if git describe doesn't finish
+// within budget 'b'; such results are not cached.
+func getDescribeResult(pwd string, commonDir string, commit string,
+	mc *memcache.Client, b budget) (describeResult, error) {
+	if commit == "" {
+		return describeResult{}, nil
+	}
+
+	var key = fmt.Sprintf("git-describe:%x:%s",
+		sha1.Sum([]byte(commonDir)), commit)
+	if mc != nil {
+		item, err := mc.Get(key)
+		if err == nil {
+			return parseDescribeCacheValue(string(item.Value)), nil
+		}
+	}
+
+	var result describeResult
+	output, err := b.runGit(pwd, "describe", "--tags", "--long", commit)
+	if err == util.ErrTimeout {
+		return result, err
+	}
+	if err == nil {
+		result = parseDescribe(output)
+	}
+	// Cache negative results too, so we don't keep paying for repos without
+	// tags.
+	if mc != nil {
+		mc.Set(&memcache.Item{
+			Key:        key,
+			Value:      []byte(result.cacheValue()),
+			Expiration: describeCacheSeconds,
+		})
+	}
+	return result, nil
+}
+
+// Parses the output of git describe --long.
+func parseDescribe(output string) describeResult {
+	var match = describeRegex.FindStringSubmatch(output)
+	if match == nil {
+		return describeResult{}
+	}
+	distance, err := strconv.Atoi(match[2])
+	if err != nil {
+		return describeResult{}
+	}
+	return describeResult{Tag: match[1], Distance: distance}
+}
+
+// Serializes a describeResult for storage in memcache.
+func (self describeResult) cacheValue() string {
+	if self.Tag == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d %s", self.Distance, self.Tag)
+}
+
+// Inverse of describeResult.cacheValue.
+func parseDescribeCacheValue(value string) describeResult {
+	var parts = strings.SplitN(value, " ", 2)
+	if len(parts) != 2 {
+		return describeResult{}
+	}
+	distance, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return describeResult{}
+	}
+	return describeResult{Tag: parts[1], Distance: distance}
+}
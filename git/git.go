@@ -1,124 +1,1037 @@
 // Library for querying info from a local Git repository.
 package git
 
-import "bufio"
+import "crypto/sha1"
+import "encoding/binary"
+import "errors"
+import "flag"
+import "fmt"
+import "os"
 import "path"
-import "regexp"
+import "strconv"
 import "strings"
+import "syscall"
+import "time"
+import "github.com/bradfitz/gomemcache/memcache"
 import . "github.com/sethpollen/sbp-go-utils/format"
 import "github.com/sethpollen/sbp-go-utils/prompt"
 import "github.com/sethpollen/sbp-go-utils/util"
 
 type GitInfo struct {
-	// Name of this Git repo.
+	// How we found this repo.
+	Layout Layout
+	// Name of this Git repo. Inside a linked worktree, this is the name of the
+	// main repo followed by "@" and the name of the worktree.
 	RepoName string
+	// Full path to the root of this repo's working tree. If we are not in a
+	// working tree (see Layout), this is the Git dir instead.
+	RepoPath string
+	// Name of the linked worktree we are in, or "" if we are in the main
+	// worktree.
+	Worktree string
+	// If this repo is a submodule, the name of its superproject and the path of
+	// the submodule within the superproject. Otherwise, both are "".
+	Superproject  string
+	SubmodulePath string
 	// Pwd, relative to the root repo path.
 	RelativePwd string
-	// The name of the current branch, or a short hash if we are in a detached
-	// head.
+	// The full name of the current branch (e.g. "feature/login"), or a short
+	// hash if we are in a detached head.
 	Branch string
-	// True iff there are uncommitted local changes.
-	Dirty bool
-	// True iff there are unpushed local commits.
-	Ahead bool
+	// The repo's default branch (e.g. "main"). We don't bother showing the
+	// branch name when it is this one.
+	DefaultBranch string
+	// Hash of the HEAD commit, or "" if there are no commits yet.
+	Commit string
+	// Committer date of the HEAD commit, or the zero time if there are no
+	// commits yet.
+	CommitTime time.Time
+	// When the repo was last fetched from, based on the modification time of
+	// FETCH_HEAD. The zero time if it has never been fetched from.
+	LastFetch time.Time
+	// Name of the upstream ref which the current branch tracks (e.g.
+	// "origin/master"), or "" if there is none.
+	Upstream string
+	// True iff the current branch tracks an upstream ref which no longer exists.
+	UpstreamGone bool
+	// Number of local commits which have not been pushed to Upstream.
+	Ahead int
+	// Number of commits on Upstream which have not been pulled locally.
+	Behind int
+	// Counts of uncommitted local changes.
+	StatusCounts
+	// True iff git status ran out of time, so we don't know whether there are
+	// uncommitted local changes. StatusCounts and the upstream fields are not
+	// filled in.
+	DirtyUnknown bool
+	// True iff StatusCounts only cover the RelativePwd subtree, because this
+	// repo is in Options.SubtreeRepos.
+	Subtree bool
+	// When Subtree is set, true iff there are uncommitted changes to tracked
+	// files outside the subtree. Untracked files outside the subtree are not
+	// considered.
+	DirtyElsewhere bool
+	// When Subtree is set, true iff we ran out of time before finding out
+	// whether DirtyElsewhere should be set.
+	DirtyElsewhereUnknown bool
+	// The multi-step operation (rebase, merge, etc.) in progress, if any.
+	Operation Operation
+	// The step of Operation we are on and the total number of steps, or zero
+	// if unknown.
+	OperationStep  int
+	OperationTotal int
+	// For a rebase, the branch (or short hash) we are rebasing onto.
+	RebaseOnto string
+	// The nearest tag reachable from HEAD, or "" if there is none.
+	Tag string
+	// Number of commits between Tag and HEAD.
+	TagDistance int
+	// True iff HEAD is exactly on Tag.
+	OnTag bool
+	// URLs of the repo's web page and of the current branch's (or detached
+	// commit's) web page on its hosting service, or "" if the repo has no
+	// recognizable remote.
+	WebURL    string
+	BranchURL string
+	// True iff this is a shallow clone, with truncated history.
+	Shallow bool
+	// True iff sparse checkout is enabled, so some tracked files may be missing
+	// from the working tree.
+	Sparse bool
+	// True iff this is a partial clone (e.g. --filter=blob:none), so some
+	// objects will be fetched on demand.
+	Partial bool
+	// True iff the repo is owned by another user and has not been marked safe
+	// with safe.directory. We don't run git in such repos, so only the fields
+	// we can read directly from the Git dir are filled in.
+	Foreign bool
+	// Branches whose names we don't show, as well as DefaultBranch. These come
+	// from the repo's prompt.hideBranch setting.
+	HiddenBranches []string
+	// Number of stashes in this repo.
+	StashCount int
+	// All linked worktrees of this repo, including the current one (if we are
+	// in a linked worktree).
+	Worktrees []Worktree
+	// True iff a query other than git status ran out of time, so some fields
+	// are missing. Such GitInfos aren't cached.
+	incomplete bool
 }
 
-// Regex to match the "branch" line from git status --branch --porcelain. If
-// this matches, the local branch is ahead of the remote branch.
-var statusBranchAheadRegex = regexp.MustCompile("^\\#\\# .* \\[ahead [0-9]+\\]$")
+var statusTimeout = flag.Duration("git_status_timeout", 500*time.Millisecond,
+	"Maximum time to wait for git commands (mainly git status) when building "+
+		"a prompt. Zero means no limit.")
+var branchStrip = flag.String("git_branch_strip", "",
+	"Comma-separated prefixes to strip from branch names, such as "+
+		"\"feature/,bugfix/\".")
+var branchShorten = flag.String("git_branch_shorten", "",
+	"Comma-separated namespace patterns, such as \"users/*\", whose "+
+		"components are shortened to their first letters in branch names.")
+var branchMaxWidth = flag.Int("git_branch_max_width", 32,
+	"Maximum number of characters of a branch name to show. Zero means no "+
+		"limit.")
+var fetchWarn = flag.Duration("git_fetch_warn", 7*24*time.Hour,
+	"Warn when a repo was last fetched longer ago than this. Zero disables "+
+		"the warning.")
+var fetchRepos = flag.String("git_fetch_repos", "",
+	"Comma-separated paths of repos to fetch in the background during "+
+		"--update_cache.")
+var fetchInterval = flag.Duration("git_fetch_interval", 15*time.Minute,
+	"Minimum time between background fetches of a repo in --git_fetch_repos.")
+var untrackedFlag = flag.String("git_untracked", "auto",
+	"Whether git status should look for untracked files: \"normal\", \"no\" "+
+		"or \"auto\" (look unless the repo is very large).")
+var subtreeRepos = flag.String("git_subtree_repos", "",
+	"Comma-separated paths of repos (such as monorepos) in which to count "+
+		"local changes only under the current directory.")
+
+// Splits a comma-separated flag value, dropping empty elements.
+func splitFlag(value string) []string {
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// Controls whether GetGitInfo looks for untracked files. Searching for
+// untracked files means walking the entire working tree, which dominates the
+// cost of git status in large repos.
+type UntrackedMode int
+
+const (
+	// Look for untracked files unless the index is very large.
+	UntrackedAuto UntrackedMode = iota
+	// Always look for untracked files.
+	UntrackedNormal
+	// Never look for untracked files.
+	UntrackedNo
+)
+
+// Parses an UntrackedMode from a flag value. Returns UntrackedAuto for
+// unrecognized values.
+func ParseUntrackedMode(value string) UntrackedMode {
+	switch value {
+	case "normal", "all", "true":
+		return UntrackedNormal
+	case "no", "false":
+		return UntrackedNo
+	}
+	return UntrackedAuto
+}
+
+// In UntrackedAuto mode, we skip the search for untracked files in repos
+// whose index has at least this many entries.
+const largeIndexEntries = 50000
+
+// Options for GetGitInfoWithOptions. The zero value caches nothing and has
+// no time limit.
+type Options struct {
+	// Used to cache slow queries and whole GitInfos. May be nil, in which case
+	// nothing is cached.
+	Memcache *memcache.Client
+	// If true, ignore any cached GitInfo and replace it with a fresh one.
+	UpdateCache bool
+	// Time budget for all the git commands GetGitInfo runs. If git status
+	// doesn't finish in time, GetGitInfo sets DirtyUnknown; if a later command
+	// doesn't, the fields it would fill in are left empty. Zero means no
+	// limit. A repo's prompt.statusTimeout setting replaces any non-zero
+	// StatusTimeout.
+	StatusTimeout time.Duration
+	// A repo's prompt.untracked setting replaces this.
+	Untracked UntrackedMode
+	// Paths of repos in which to scope git status to the subtree containing
+	// pwd, reporting changes elsewhere only as DirtyElsewhere. This keeps the
+	// prompt meaningful in monorepos, where something is always dirty. A
+	// repo's prompt.dirtyScope setting ("subtree" or "repo") takes precedence.
+	SubtreeRepos []string
+}
+
+// Returns the path of the subtree of the repo at 'loc' to which git status
+// should be scoped, relative to the repo root, or "" if status should cover
+// the whole repo.
+func (self Options) statusSubtree(pwd string, loc *repoLocation) string {
+	for _, repo := range self.SubtreeRepos {
+		if path.Clean(repo) != loc.RepoPath {
+			continue
+		}
+		var relativePwd = util.RelativePath(pwd, loc.RepoPath)
+		if relativePwd == "/" {
+			// The subtree is the whole repo.
+			return ""
+		}
+		return relativePwd
+	}
+	return ""
+}
+
+// Runs git with 'args' in 'pwd', killing it if it takes longer than
+// 'timeout'. A 'timeout' of zero means no limit.
+func runGit(pwd string, timeout time.Duration, args ...string) (string,
+	error) {
+	return util.EvalCmdTimeout(gitCommand(pwd, args...), timeout)
+}
+
+// A time budget shared by the git commands run for one GetGitInfo call, so
+// that the call as a whole finishes on time.
+type budget struct {
+	start   time.Time
+	timeout time.Duration
+}
+
+// Returns a budget of 'timeout' from 'start'. A zero timeout means no limit.
+func newBudget(start time.Time, timeout time.Duration) budget {
+	return budget{start, timeout}
+}
+
+// Like runGit, but limited to what is left of the budget. Returns
+// util.ErrTimeout without running git if nothing is left.
+func (self budget) runGit(pwd string, args ...string) (string, error) {
+	if self.timeout == 0 {
+		return runGit(pwd, 0, args...)
+	}
+	var left = self.timeout - time.Since(self.start)
+	if left <= 0 {
+		return "", util.ErrTimeout
+	}
+	return runGit(pwd, left, args...)
+}
+
+// Reads the number of entries in the index file in 'gitDir' from its header.
+// Returns 0 if the index can't be read.
+func countIndexEntries(gitDir string) int {
+	file, err := os.Open(path.Join(gitDir, "index"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	// The header is a "DIRC" signature, a 4-byte version and a 4-byte entry
+	// count, all big-endian.
+	var header [12]byte
+	if _, err = file.Read(header[:]); err != nil ||
+		string(header[:4]) != "DIRC" {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(header[8:]))
+}
+
+// How the current directory relates to a repo. This determines what we can
+// find out about the repo, and how we show it.
+type Layout int
+
+const (
+	// Inside the working tree of a repo.
+	LayoutWorkTree Layout = iota
+	// Inside a bare repo, which has no working tree.
+	LayoutBare
+	// Inside the Git dir of a repo whose working tree is elsewhere, such as
+	// .git.
+	LayoutGitDir
+	// The GIT_DIR environment variable chose the repo, as is common for
+	// dotfiles kept in a bare repo. The working tree comes from GIT_WORK_TREE
+	// or core.worktree, and we may be outside it.
+	LayoutEnv
+)
+
+// Returns a short description of this Layout, or "" for LayoutWorkTree.
+func (self Layout) String() string {
+	switch self {
+	case LayoutBare:
+		return "bare"
+	case LayoutGitDir:
+		return "git dir"
+	case LayoutEnv:
+		return "GIT_DIR"
+	}
+	return ""
+}
+
+// True iff git status can run in this Layout.
+func (self Layout) hasWorkTree() bool {
+	return self == LayoutWorkTree || self == LayoutEnv
+}
+
+// Where a repo lives on disk.
+type repoLocation struct {
+	Layout Layout
+	// Root of the working tree. Without a working tree, this is the Git dir.
+	RepoPath string
+	// The Git dir for this working tree.
+	GitDir string
+	// The Git dir shared by all worktrees of the repo. This is the same as
+	// GitDir except in linked worktrees.
+	CommonDir string
+	// Root of the superproject's working tree if this repo is a submodule, or
+	// "" otherwise.
+	SuperPath string
+}
+
+// Finds the repo which contains 'pwd'. Git honours GIT_DIR and GIT_WORK_TREE
+// itself, since it inherits our environment.
+func locateRepo(pwd string, b budget) (*repoLocation, error) {
+	// --show-superproject-working-tree prints nothing unless we are in a
+	// submodule, so it must come last.
+	revParse, err := b.runGit(pwd, "rev-parse",
+		"--show-toplevel", "--absolute-git-dir", "--git-common-dir",
+		"--show-superproject-working-tree")
+	if err == util.ErrTimeout {
+		return nil, err
+	}
+	if err != nil {
+		// --show-toplevel fails if there is no working tree. Try again without
+		// it, in case we are in a bare repo or a Git dir.
+		return locateGitDir(pwd, b)
+	}
+	var revParseLines = strings.Split(revParse, "\n")
+	if len(revParseLines) != 3 && len(revParseLines) != 4 {
+		return nil, errors.New("Unexpected git rev-parse output")
+	}
+	var loc = new(repoLocation)
+	if os.Getenv("GIT_DIR") != "" {
+		loc.Layout = LayoutEnv
+	}
+	loc.RepoPath = revParseLines[0]
+	loc.GitDir = revParseLines[1]
+	loc.CommonDir = revParseLines[2]
+	if !path.IsAbs(loc.CommonDir) {
+		loc.CommonDir = path.Join(pwd, loc.CommonDir)
+	}
+	if len(revParseLines) == 4 {
+		loc.SuperPath = revParseLines[3]
+	}
+	return loc, nil
+}
+
+// Finds the repo which contains 'pwd' without running git, searching in the
+// same order as git: at each level, a .git entry and then the directory itself
+// as a Git dir. Unlike locateRepo, this doesn't detect submodules and ignores
+// GIT_DIR.
+func locateRepoNatively(pwd string) (*repoLocation, error) {
+	for p := path.Clean(pwd); ; p = path.Dir(p) {
+		if fileExists(path.Join(p, ".git")) {
+			repoPath, gitDir, err := findWorkTree(p)
+			if err != nil {
+				return nil, err
+			}
+			var loc = new(repoLocation)
+			loc.RepoPath = repoPath
+			loc.GitDir = gitDir
+			loc.CommonDir = commonGitDir(gitDir)
+			return loc, nil
+		}
+		if isGitDir(p) {
+			var loc = new(repoLocation)
+			loc.GitDir = p
+			loc.RepoPath = p
+			loc.CommonDir = commonGitDir(p)
+			var config = readRepoConfig(loc.GitDir, loc.CommonDir)
+			if bare, _ := config.GetBool("core.bare"); bare {
+				loc.Layout = LayoutBare
+			} else {
+				loc.Layout = LayoutGitDir
+			}
+			return loc, nil
+		}
+		if p == "/" || p == "." {
+			return nil, errors.New("Not in a Git repo")
+		}
+	}
+}
+
+// True iff 'dir' looks like a Git dir to git: it has a HEAD file, and either
+// objects and refs directories or (for a linked worktree) a commondir file.
+func isGitDir(dir string) bool {
+	fileInfo, err := os.Stat(path.Join(dir, "HEAD"))
+	if err != nil || fileInfo.IsDir() {
+		return false
+	}
+	if fileExists(path.Join(dir, "commondir")) {
+		return true
+	}
+	for _, name := range []string{"objects", "refs"} {
+		fileInfo, err = os.Stat(path.Join(dir, name))
+		if err != nil || !fileInfo.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds the repo which contains 'pwd', given that 'pwd' is not in a working
+// tree.
+func locateGitDir(pwd string, b budget) (*repoLocation, error) {
+	revParse, err := b.runGit(pwd, "rev-parse",
+		"--is-bare-repository", "--absolute-git-dir", "--git-common-dir")
+	if err != nil {
+		return nil, err
+	}
+	var revParseLines = strings.Split(revParse, "\n")
+	if len(revParseLines) != 3 {
+		return nil, errors.New("Unexpected git rev-parse output")
+	}
+	var loc = new(repoLocation)
+	// This holds even if GIT_DIR chose the repo, since without a working tree
+	// we can't treat it as LayoutEnv.
+	if revParseLines[0] == "true" {
+		loc.Layout = LayoutBare
+	} else {
+		loc.Layout = LayoutGitDir
+	}
+	loc.GitDir = revParseLines[1]
+	loc.RepoPath = loc.GitDir
+	loc.CommonDir = revParseLines[2]
+	if !path.IsAbs(loc.CommonDir) {
+		loc.CommonDir = path.Join(pwd, loc.CommonDir)
+	}
+	return loc, nil
+}
 
 // Queries a GitInfo for the repository that parents 'pwd'. If 'pwd' is not in
 // a Git repository, returns an error.
 func GetGitInfo(pwd string) (*GitInfo, error) {
-	repoPath, err :=
-		util.EvalCommandSync(pwd, "git", "rev-parse", "--show-toplevel")
+	return GetGitInfoWithOptions(pwd, Options{})
+}
+
+// Like GetGitInfo, but with caching, time limits and so on controlled by
+// 'opts'.
+func GetGitInfoWithOptions(pwd string, opts Options) (*GitInfo, error) {
+	// Check ownership before spawning git, since even git rev-parse reads the
+	// repo's config. Like git, we trust a repo named explicitly by GIT_DIR.
+	if os.Getenv("GIT_DIR") == "" {
+		if loc, err := locateRepoNatively(pwd); err == nil &&
+			isForeignRepo(loc.RepoPath, loc.GitDir) {
+			return foreignGitInfo(pwd, loc), nil
+		}
+	}
+
+	// Every git command we run shares one time budget.
+	var start = time.Now()
+	loc, err := locateRepo(pwd, newBudget(start, opts.StatusTimeout))
+	if err == util.ErrTimeout && os.Getenv("GIT_DIR") == "" {
+		// Find what we can without git, and let the rest of the queries time out.
+		loc, err = locateRepoNatively(pwd)
+	}
 	if err != nil {
 		return nil, err
 	}
+	var config = readRepoConfig(loc.GitDir, loc.CommonDir)
+	opts = opts.withRepoConfig(config, loc.RepoPath)
+	var b = newBudget(start, opts.StatusTimeout)
 
-	branch, err := util.EvalCommandSync(pwd, "git", "symbolic-ref", "HEAD")
-	if err == nil {
-		var branchParts = strings.Split(branch, "/")
-		branch = branchParts[len(branchParts)-1]
-	} else {
-		// We may be in a detached head. In that case, find the hash of the detached
-		// head revision.
-		branch, err =
-			util.EvalCommandSync(pwd, "git", "rev-parse", "--short", "HEAD")
-		if err != nil {
-			return nil, err
+	// Scoped results depend on where we are, so they are cached per subtree.
+	var subtree = opts.statusSubtree(pwd, loc)
+	var cacheKey = infoCacheKey(loc.RepoPath, subtree)
+
+	// Take the stamp before querying anything, so that changes made while we
+	// query will invalidate what we store.
+	var stamp string
+	if opts.Memcache != nil {
+		stamp = repoStamp(loc.GitDir, loc.CommonDir)
+		if !opts.UpdateCache {
+			var info = loadCachedInfo(opts.Memcache, cacheKey, stamp)
+			if info != nil {
+				info.RelativePwd = util.RelativePath(pwd, loc.RepoPath)
+				info.applyRepoConfig(config)
+				return info, nil
+			}
 		}
 	}
 
-	status, err :=
-		util.EvalCommandSync(pwd, "git", "status", "--branch", "--porcelain")
+	info, err := queryGitInfo(pwd, loc, subtree, config, opts, b)
 	if err != nil {
 		return nil, err
 	}
+	// Don't cache incomplete results.
+	if opts.Memcache != nil && !info.DirtyUnknown &&
+		!info.DirtyElsewhereUnknown && !info.incomplete {
+		storeCachedInfo(opts.Memcache, cacheKey, stamp, info)
+	}
+	// Apply display settings after storing, so that the cache doesn't hold on
+	// to them after they are removed from the config.
+	info.applyRepoConfig(config)
+	return info, nil
+}
 
+// Builds a GitInfo for a foreign repo without running git, from what we can
+// read directly from its Git dir.
+func foreignGitInfo(pwd string, loc *repoLocation) *GitInfo {
 	var info = new(GitInfo)
-	info.RepoName = path.Base(repoPath)
-	info.RelativePwd = util.RelativePath(pwd, repoPath)
-	info.Branch = branch
-
-	info.Dirty = false
-	info.Ahead = false
-
-	// Parse the git status result.
-	var scanner = bufio.NewScanner(strings.NewReader(status))
-	// Stop looping of we set both Ahead and Dirty to true.
-	for scanner.Scan() && !(info.Ahead && info.Dirty) {
-		var line = scanner.Text()
-		if strings.HasPrefix(line, "## ") {
-			// This is the "branch" line.
-			if statusBranchAheadRegex.FindStringIndex(line) != nil {
-				info.Ahead = true
+	info.Foreign = true
+	info.Layout = loc.Layout
+	info.RepoName = path.Base(loc.RepoPath)
+	if loc.Layout != LayoutWorkTree {
+		info.RepoName = mainRepoName(loc.CommonDir)
+	}
+	info.RepoPath = loc.RepoPath
+	info.RelativePwd = util.RelativePath(pwd, loc.RepoPath)
+	info.Commit = resolveHead(loc.GitDir, loc.CommonDir)
+	info.Branch = headBranch(loc.GitDir)
+	info.DirtyUnknown = loc.Layout.hasWorkTree()
+	info.DefaultBranch = defaultBranch(
+		readRepoConfig(loc.GitDir, loc.CommonDir), loc.CommonDir, loc.GitDir)
+	return info
+}
+
+// Does the work of GetGitInfo, without consulting the GitInfo cache.
+func queryGitInfo(pwd string, loc *repoLocation, subtree string,
+	config Config, opts Options, b budget) (*GitInfo, error) {
+	var status string
+	var err error
+	var elsewhere <-chan elsewhereResult
+	if loc.Layout.hasWorkTree() {
+		// A single git status call gives us both the branch info and the state
+		// of the working tree.
+		var statusArgs = []string{"status", "--porcelain=v2", "--branch"}
+		switch opts.Untracked {
+		case UntrackedNormal:
+			statusArgs = append(statusArgs, "--untracked-files=normal")
+		case UntrackedNo:
+			statusArgs = append(statusArgs, "--untracked-files=no")
+		case UntrackedAuto:
+			// Otherwise, git status uses the repo's status.showUntrackedFiles
+			// setting.
+			if countIndexEntries(loc.GitDir) >= largeIndexEntries {
+				statusArgs = append(statusArgs, "--untracked-files=no")
 			}
-		} else {
-			// This is not the "branch" line, so it must indicate that a file is
-			// dirty.
-			info.Dirty = true
+		}
+		if subtree != "" {
+			// Check the rest of the repo in parallel with the scoped status.
+			elsewhere =
+				checkDirtyElsewhere(loc.RepoPath, subtree, b)
+			// Pathspecs are relative to pwd, which is the root of the subtree.
+			statusArgs = append(statusArgs, "--", ".")
+		}
+		status, err = b.runGit(pwd, statusArgs...)
+		if err != nil && err != util.ErrTimeout {
+			return nil, err
 		}
 	}
 
+	var info = new(GitInfo)
+	info.RepoName = path.Base(loc.RepoPath)
+	info.RepoPath = loc.RepoPath
+	if path.Clean(loc.GitDir) != path.Clean(loc.CommonDir) {
+		// We are in a linked worktree, whose Git dir lives under the main repo's
+		// Git dir.
+		info.Worktree = path.Base(loc.GitDir)
+		info.RepoName = mainRepoName(loc.CommonDir) + "@" + info.Worktree
+	}
+	if loc.SuperPath != "" {
+		// We are in a submodule. Identify it by its superproject.
+		info.Superproject = path.Base(loc.SuperPath)
+		info.SubmodulePath = util.RelativePath(loc.RepoPath, loc.SuperPath)
+		info.RepoName = info.Superproject + "/" + info.SubmodulePath
+	}
+	info.Layout = loc.Layout
+	if loc.Layout != LayoutWorkTree {
+		// The working tree doesn't tell us the repo's name.
+		info.RepoName = mainRepoName(loc.CommonDir)
+	}
+	info.RelativePwd = util.RelativePath(pwd, loc.RepoPath)
+	if !loc.Layout.hasWorkTree() || err == util.ErrTimeout {
+		// Make do with what we can find out without git status.
+		info.DirtyUnknown = err == util.ErrTimeout
+		info.Commit = resolveHead(loc.GitDir, loc.CommonDir)
+		info.Branch = headBranch(loc.GitDir)
+	} else {
+		info.applyStatus(parseStatus(status))
+	}
+	if elsewhere != nil {
+		var result = <-elsewhere
+		info.Subtree = true
+		info.DirtyElsewhere = result.Dirty
+		info.DirtyElsewhereUnknown = result.Unknown
+	}
+	// Describing a new commit can be slow in a large repo. If it takes too
+	// long, the --update_cache pass will fill in the tag.
+	describe, err := getDescribeResult(pwd, loc.CommonDir, info.Commit,
+		opts.Memcache, b)
+	if err == util.ErrTimeout {
+		info.incomplete = true
+	}
+	info.Tag = describe.Tag
+	info.TagDistance = describe.Distance
+	info.OnTag = describe.Tag != "" && describe.Distance == 0
+	if info.Commit != "" {
+		info.CommitTime, err = getCommitTime(pwd, info.Commit, b)
+		if err == util.ErrTimeout {
+			info.incomplete = true
+		}
+	}
+	fetchHead, err := os.Stat(path.Join(loc.CommonDir, "FETCH_HEAD"))
+	if err == nil {
+		info.LastFetch = fetchHead.ModTime()
+	}
+	info.Shallow = fileExists(path.Join(loc.CommonDir, "shallow"))
+	info.Sparse, _ = config.GetBool("core.sparseCheckout")
+	info.Partial = isPartialClone(config)
+	info.DefaultBranch = defaultBranch(config, loc.CommonDir, loc.GitDir)
+	info.applyOperationState(getOperationState(loc.GitDir), loc.CommonDir,
+		config)
+	remote, err := readRemote(config, loc.GitDir)
+	if err == nil {
+		info.WebURL = remote.WebURL()
+		if branch := localBranch(loc.GitDir); branch != "" {
+			info.BranchURL = remote.BranchURL(branch)
+		} else if info.Commit != "" {
+			info.BranchURL = remote.CommitURL(info.Commit)
+		}
+	}
+	info.StashCount = countStashes(loc.CommonDir)
+	info.Worktrees = listWorktrees(loc.CommonDir)
 	return info, nil
 }
 
+// The outcome of checkDirtyElsewhere.
+type elsewhereResult struct {
+	Dirty   bool
+	Unknown bool
+}
+
+// Starts checking in the background whether the repo rooted at 'repoPath' has
+// changes to tracked files outside of 'subtree' (relative to the repo root).
+// The result is sent on the returned channel. Untracked files are skipped, to
+// avoid walking the whole working tree.
+func checkDirtyElsewhere(repoPath string, subtree string,
+	b budget) <-chan elsewhereResult {
+	var result = make(chan elsewhereResult, 1)
+	go func() {
+		status, err := b.runGit(repoPath,
+			"status", "--porcelain=v2", "--untracked-files=no")
+		if err != nil {
+			result <- elsewhereResult{Unknown: true}
+			return
+		}
+		// We run from the repo root, so status paths are relative to it.
+		var prefix = strings.TrimSuffix(subtree, "/") + "/"
+		for _, entry := range parseStatus(status).Entries {
+			if !strings.HasPrefix(entry.Path, prefix) {
+				result <- elsewhereResult{Dirty: true}
+				return
+			}
+		}
+		result <- elsewhereResult{}
+	}()
+	return result
+}
+
+// True iff 'config' belongs to a partial clone. Such repos have at least one
+// promisor remote, from which missing objects are fetched on demand.
+func isPartialClone(config Config) bool {
+	if config.Get("extensions.partialClone") != "" {
+		return true
+	}
+	for key := range config {
+		if strings.HasPrefix(key, "remote.") &&
+			strings.HasSuffix(key, ".promisor") {
+			if promisor, _ := config.GetBool(key); promisor {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the committer date of 'commit', or the zero time if it can't be
+// read. Returns util.ErrTimeout if the budget runs out first.
+func getCommitTime(pwd string, commit string, b budget) (time.Time, error) {
+	output, err := b.runGit(pwd, "log", "-1", "--format=%ct", commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// Fills in the fields of 'info' which come from git status.
+func (info *GitInfo) applyStatus(status *statusResult) {
+	if status.Oid != "(initial)" {
+		info.Commit = status.Oid
+	}
+	if status.Head == "(detached)" {
+		// Show a short hash of the detached head revision.
+		info.Branch = info.Commit
+		if len(info.Branch) > 7 {
+			info.Branch = info.Branch[:7]
+		}
+	} else {
+		info.Branch = status.Head
+	}
+
+	info.Upstream = status.Upstream
+	info.UpstreamGone = status.Upstream != "" && !status.HasAheadBehind
+	info.Ahead = status.Ahead
+	info.Behind = status.Behind
+	info.StatusCounts = countEntries(status.Entries)
+}
+
+// Fills in the Operation fields of 'info'. DefaultBranch must already be
+// filled in.
+func (info *GitInfo) applyOperationState(state operationState,
+	commonDir string, config Config) {
+	info.Operation = state.Operation
+	info.OperationStep = state.Step
+	info.OperationTotal = state.Total
+	var upstream string
+	if state.HeadName != "" {
+		// We are rebasing a branch. HEAD is detached, so show the name of the
+		// branch instead.
+		info.Branch = strings.TrimPrefix(state.HeadName, "refs/heads/")
+		upstream = upstreamBranch(config, info.Branch)
+	}
+	if state.Onto != "" {
+		info.RebaseOnto = pickOntoName(
+			findBranchesForHash(commonDir, state.Onto),
+			upstream, info.DefaultBranch, state.Onto)
+	}
+}
+
+// Names the commit a rebase is onto, given the branches which point at it.
+// Several branches often share a commit, so we prefer the rebased branch's
+// upstream, then the default branch. Otherwise, a name is only used if it is
+// the only one; an ambiguous name is replaced by a short hash.
+func pickOntoName(candidates []string, upstream string,
+	defaultBranch string, onto string) string {
+	for _, preferred := range []string{upstream, defaultBranch} {
+		for _, candidate := range candidates {
+			if preferred != "" && candidate == preferred {
+				return candidate
+			}
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	if len(onto) > 7 {
+		return onto[:7]
+	}
+	return onto
+}
+
+// Describes the operation in progress, such as "REBASE 2/5 onto master".
+// Returns "" if there is no operation in progress.
+func (info *GitInfo) OperationString() string {
+	var str = info.Operation.String()
+	if str == "" {
+		return ""
+	}
+	if info.OperationTotal > 0 {
+		str += fmt.Sprintf(" %d/%d", info.OperationStep, info.OperationTotal)
+	}
+	if info.RebaseOnto != "" {
+		str += " onto " + info.RebaseOnto
+	}
+	return str
+}
+
 // Formats a GitInfo as a string, suitable for use as an 'info' string in a
 // prompt.
 func (info *GitInfo) String() string {
+	return info.Format(BranchAbbrev{})
+}
+
+// Like String, but abbreviates the branch name according to 'abbrev'.
+func (info *GitInfo) Format(abbrev BranchAbbrev) string {
 	var str = info.RepoName
-	if info.Branch != "master" {
-		str += ": " + info.Branch
+	if info.Branch != info.DefaultBranch && !info.branchHidden() {
+		str += ": " + abbrev.Apply(info.Branch)
 	}
-	if info.Ahead || info.Dirty {
-		str += " "
-		if info.Ahead {
-			str += "^"
-		}
-		if info.Dirty {
-			str += "*"
-		}
+	var markers []string
+	if info.Layout != LayoutWorkTree {
+		markers = append(markers, "("+info.Layout.String()+")")
+	}
+	if info.Foreign {
+		markers = append(markers, "(foreign)")
+	}
+	var cloneKinds []string
+	if info.Shallow {
+		cloneKinds = append(cloneKinds, "sh")
+	}
+	if info.Sparse {
+		cloneKinds = append(cloneKinds, "sp")
+	}
+	if info.Partial {
+		cloneKinds = append(cloneKinds, "pc")
+	}
+	if len(cloneKinds) > 0 {
+		markers = append(markers, "{"+strings.Join(cloneKinds, ",")+"}")
+	}
+	if info.OnTag {
+		markers = append(markers, info.Tag)
+	} else if info.Tag != "" {
+		markers = append(markers, fmt.Sprintf("%s+%d", info.Tag, info.TagDistance))
+	}
+	switch {
+	case info.UpstreamGone:
+		markers = append(markers, "(gone)")
+	case info.Ahead > 0 && info.Behind > 0:
+		// The local and upstream branches have diverged.
+		markers = append(markers, fmt.Sprintf("±%d/%d", info.Ahead, info.Behind))
+	case info.Ahead > 0:
+		markers = append(markers, fmt.Sprintf("^%d", info.Ahead))
+	case info.Behind > 0:
+		markers = append(markers, fmt.Sprintf("v%d", info.Behind))
+	}
+	if info.DirtyUnknown {
+		markers = append(markers, "?")
+	} else if info.Dirty() {
+		markers = append(markers, info.StatusCounts.String())
+	}
+	if info.DirtyElsewhereUnknown {
+		markers = append(markers, "(?)")
+	} else if info.DirtyElsewhere {
+		markers = append(markers, "(*)")
+	}
+	if info.StashCount > 0 {
+		markers = append(markers, fmt.Sprintf("#%d", info.StashCount))
+	}
+	var otherWorktrees = len(info.Worktrees)
+	if info.Worktree != "" {
+		otherWorktrees--
+	}
+	if otherWorktrees > 0 {
+		markers = append(markers, fmt.Sprintf("&%d", otherWorktrees))
+	}
+	if len(markers) > 0 {
+		str += " " + strings.Join(markers, " ")
 	}
 	return str
 }
 
+// True iff Branch is in HiddenBranches.
+func (info *GitInfo) branchHidden() bool {
+	for _, branch := range info.HiddenBranches {
+		if branch == info.Branch {
+			return true
+		}
+	}
+	return false
+}
+
 // A prompt.Modlue that matches any directory inside a Git repo.
 type module struct{}
 
 func (self module) Prepare(env *prompt.PromptEnv) {}
 
 func (self module) Match(env *prompt.PromptEnv, updateCache bool) bool {
-	gitInfo, err := GetGitInfo(env.Pwd)
+	var opts = Options{
+		Memcache:      env.Memcache,
+		UpdateCache:   updateCache,
+		StatusTimeout: *statusTimeout,
+		Untracked:     ParseUntrackedMode(*untrackedFlag),
+		SubtreeRepos:  splitFlag(*subtreeRepos),
+	}
+	if updateCache {
+		// This pass runs in the background, so it can afford to wait.
+		opts.StatusTimeout = 0
+	}
+	gitInfo, err := GetGitInfoWithOptions(env.Pwd, opts)
 	if err != nil {
 		return false
 	}
-	env.Info = gitInfo.String()
+	env.Info = gitInfo.Format(BranchAbbrev{
+		StripPrefixes:     splitFlag(*branchStrip),
+		ShortenNamespaces: splitFlag(*branchShorten),
+		MaxWidth:          *branchMaxWidth,
+	})
 	env.Flag = append(env.Flag, Stylize("git", Red, Intense)...)
+	if gitInfo.Operation != OpNone {
+		// Make it hard to miss that we are in the middle of something.
+		env.Flag = append(env.Flag, Unstyled(" ")...)
+		env.Flag = append(env.Flag,
+			Stylize(gitInfo.OperationString(), Yellow, Bold)...)
+	}
+	if env.Memcache != nil && !gitInfo.Foreign {
+		// Listing every branch is too slow to do inline, so the background pass
+		// leaves the result in memcache.
+		var unpushed []string
+		if updateCache {
+			if branches, err := ListBranches(env.Pwd); err == nil {
+				unpushed = unpushedBranches(branches)
+				storeUnpushedBranches(env.Memcache, gitInfo.RepoPath, unpushed)
+			}
+		} else {
+			unpushed = loadUnpushedBranches(env.Memcache, gitInfo.RepoPath)
+		}
+		if hasOtherBranch(unpushed, gitInfo.Branch) {
+			env.Info += " (^)"
+		}
+	}
+	if *fetchWarn > 0 && !gitInfo.LastFetch.IsZero() &&
+		env.Now.Sub(gitInfo.LastFetch) > *fetchWarn {
+		env.Info += " (fetch)"
+	}
+	var project = util.FindProject(env.Pwd, gitInfo.RepoPath,
+		env.ProjectMarkers)
+	if project != "" {
+		env.Info += " | " + project
+	}
+	if age := gitInfo.AgeString(env.Now); age != "" {
+		// Keep anything an earlier module put there.
+		if env.Info2 != "" {
+			env.Info2 += " | "
+		}
+		env.Info2 += age
+	}
 	env.Pwd = gitInfo.RelativePwd
+	if updateCache && !gitInfo.Foreign {
+		maybeFetch(gitInfo, env.Now, env.Memcache)
+	}
+	// Export links to the repo's web pages, so that the shell can offer them.
+	if gitInfo.WebURL != "" {
+		env.EnvironMod.SetVar("GIT_WEB_URL", gitInfo.WebURL)
+		env.EnvironMod.SetVar("GIT_BRANCH_URL", gitInfo.BranchURL)
+	} else {
+		env.EnvironMod.UnsetVar("GIT_WEB_URL")
+		env.EnvironMod.UnsetVar("GIT_BRANCH_URL")
+	}
 	return true
 }
 
+// Describes how old HEAD is and how long ago the repo was fetched from, such
+// as "3h, fetched 2d ago". Returns "" if neither is known.
+func (info *GitInfo) AgeString(now time.Time) string {
+	var parts []string
+	if !info.CommitTime.IsZero() {
+		parts = append(parts, util.ShortDuration(now.Sub(info.CommitTime)))
+	}
+	if !info.LastFetch.IsZero() {
+		parts = append(parts,
+			"fetched "+util.ShortDuration(now.Sub(info.LastFetch))+" ago")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Starts a background fetch of the repo described by 'info' if it is in
+// --git_fetch_repos and hasn't been fetched or tried in the last
+// --git_fetch_interval. Attempts are recorded in 'mc', since a failed or
+// unfinished fetch doesn't update FETCH_HEAD. Without 'mc', only successful
+// fetches count. Doesn't wait for the fetch to finish.
+func maybeFetch(info *GitInfo, now time.Time, mc *memcache.Client) {
+	if now.Sub(info.LastFetch) < *fetchInterval {
+		return
+	}
+	for _, repo := range splitFlag(*fetchRepos) {
+		if path.Clean(repo) != info.RepoPath {
+			continue
+		}
+		if mc != nil && !claimFetchAttempt(mc, info.RepoPath, *fetchInterval) {
+			return
+		}
+		var cmd = gitCommand(info.RepoPath, "fetch", "--quiet")
+		// Never wait for credentials or host key confirmations; there's nobody
+		// to answer. GIT_TERMINAL_PROMPT only covers git's own prompts, so ssh
+		// needs BatchMode, and a new session keeps it off the user's terminal.
+		cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0",
+			"GIT_SSH_COMMAND="+batchSSHCommand(info.RepoPath))
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		// We exit right after this, so there is no point waiting for the fetch.
+		cmd.Start()
+		return
+	}
+}
+
+// Returns the ssh command git would use in the repo at 'repoPath', changed so
+// that ssh fails instead of prompting.
+func batchSSHCommand(repoPath string) string {
+	var command = os.Getenv("GIT_SSH_COMMAND")
+	if command == "" {
+		if gitDir, commonDir, err := findGitDir(repoPath); err == nil {
+			command = readRepoConfig(gitDir, commonDir).Get("core.sshCommand")
+		}
+	}
+	if command == "" {
+		command = "ssh"
+	}
+	return command + " -o BatchMode=yes"
+}
+
+// Records in 'mc' that we are about to fetch the repo at 'repoPath'. Returns
+// false if there was already an attempt in the last 'interval', in which case
+// we shouldn't fetch.
+func claimFetchAttempt(mc *memcache.Client, repoPath string,
+	interval time.Duration) bool {
+	// Memcache treats expirations over 30 days as absolute times.
+	var seconds = int32(interval / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	} else if seconds > 30*24*60*60 {
+		seconds = 30 * 24 * 60 * 60
+	}
+	// Add fails if the key is already there, so only one pass gets to fetch.
+	var err = mc.Add(&memcache.Item{
+		Key:        fmt.Sprintf("git-fetch:%x", sha1.Sum([]byte(repoPath))),
+		Value:      []byte("1"),
+		Expiration: seconds,
+	})
+	return err == nil
+}
+
 func (self module) Description() string {
 	return "git"
 }
@@ -0,0 +1,335 @@
+package git
+
+import "os"
+import "testing"
+import "time"
+import "github.com/sethpollen/sbp-go-utils/util"
+
+func TestApplyStatusNoUpstream(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus(
+		"# branch.oid 0123456789abcdef\n# branch.head master"))
+	if info.Branch != "master" {
+		t.Errorf("Expected \"master\", got \"%s\"", info.Branch)
+	}
+	if info.Commit != "0123456789abcdef" {
+		t.Errorf("Expected \"0123456789abcdef\", got \"%s\"", info.Commit)
+	}
+	if info.Upstream != "" || info.UpstreamGone || info.Dirty() {
+		t.Errorf("Expected no upstream and no changes, got %+v", *info)
+	}
+}
+
+func TestApplyStatusFullBranchName(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus(
+		"# branch.oid 0123456789abcdef\n# branch.head feature/login"))
+	if info.Branch != "feature/login" {
+		t.Errorf("Expected \"feature/login\", got \"%s\"", info.Branch)
+	}
+}
+
+func TestApplyStatusDetached(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus(
+		"# branch.oid 0123456789abcdef\n# branch.head (detached)"))
+	if info.Branch != "0123456" {
+		t.Errorf("Expected \"0123456\", got \"%s\"", info.Branch)
+	}
+}
+
+func TestApplyStatusInitial(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus(
+		"# branch.oid (initial)\n# branch.head master"))
+	if info.Commit != "" {
+		t.Errorf("Expected \"\", got \"%s\"", info.Commit)
+	}
+}
+
+func TestApplyStatusAheadAndBehind(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus("# branch.oid 0123456789abcdef\n" +
+		"# branch.head master\n" +
+		"# branch.upstream origin/master\n" +
+		"# branch.ab +2 -3"))
+	if info.Upstream != "origin/master" {
+		t.Errorf("Expected \"origin/master\", got \"%s\"", info.Upstream)
+	}
+	if info.UpstreamGone {
+		t.Error("Didn't expect UpstreamGone")
+	}
+	if info.Ahead != 2 || info.Behind != 3 {
+		t.Errorf("Expected ahead 2, behind 3, got ahead %d, behind %d",
+			info.Ahead, info.Behind)
+	}
+}
+
+func TestApplyStatusGone(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus("# branch.oid 0123456789abcdef\n" +
+		"# branch.head feature\n" +
+		"# branch.upstream origin/feature"))
+	if !info.UpstreamGone {
+		t.Error("Expected UpstreamGone")
+	}
+}
+
+func TestApplyStatusCounts(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus("# branch.oid 0123456789abcdef\n" +
+		"# branch.head master\n" +
+		"1 M. N... 100644 100644 100644 aaaa bbbb staged.txt\n" +
+		"1 MM N... 100644 100644 100644 aaaa bbbb both.txt\n" +
+		"1 .M N... 100644 100644 100644 aaaa bbbb modified file.txt\n" +
+		"1 .D N... 100644 100644 000000 aaaa aaaa deleted.txt\n" +
+		"2 R. N... 100644 100644 100644 aaaa aaaa R100 new.txt\told.txt\n" +
+		"u UU N... 100644 100644 100644 100644 aaaa bbbb cccc conflict.txt\n" +
+		"? untracked.txt\n" +
+		"! ignored.txt"))
+	var expected = StatusCounts{
+		Staged:    2,
+		Renamed:   1,
+		Modified:  2,
+		Deleted:   1,
+		Untracked: 1,
+		Unmerged:  1,
+	}
+	if info.StatusCounts != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info.StatusCounts)
+	}
+}
+
+func TestApplyStatusSubmodules(t *testing.T) {
+	var info = new(GitInfo)
+	info.applyStatus(parseStatus("# branch.oid 0123456789abcdef\n" +
+		"# branch.head master\n" +
+		"1 .M S.M. 160000 160000 160000 aaaa aaaa dirty\n" +
+		"1 .M SC.. 160000 160000 160000 aaaa aaaa moved\n" +
+		"1 M. S... 160000 160000 160000 aaaa bbbb staged"))
+	var expected = StatusCounts{Staged: 1, Submodules: 2}
+	if info.StatusCounts != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info.StatusCounts)
+	}
+}
+
+func TestParseStatusPaths(t *testing.T) {
+	var result = parseStatus(
+		"1 .M N... 100644 100644 100644 aaaa bbbb dir/a file.txt\n" +
+			"2 R. N... 100644 100644 100644 aaaa aaaa R100 dir/new.txt\told.txt\n" +
+			"? dir/untracked.txt")
+	var expected = []string{"dir/a file.txt", "dir/new.txt", "dir/untracked.txt"}
+	if len(result.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected),
+			len(result.Entries))
+	}
+	for i, entry := range result.Entries {
+		if entry.Path != expected[i] {
+			t.Errorf("Expected \"%s\", got \"%s\"", expected[i], entry.Path)
+		}
+	}
+}
+
+func TestStatusCountsString(t *testing.T) {
+	var counts = StatusCounts{Staged: 2, Modified: 3, Untracked: 1, Unmerged: 1}
+	if counts.String() != "+2 ~3 ?1 !1" {
+		t.Errorf("Expected \"+2 ~3 ?1 !1\", got \"%s\"", counts.String())
+	}
+	if (StatusCounts{}).String() != "" {
+		t.Errorf("Expected \"\", got \"%s\"", StatusCounts{}.String())
+	}
+}
+
+func TestString(t *testing.T) {
+	var cases = []struct {
+		info     GitInfo
+		expected string
+	}{
+		{GitInfo{RepoName: "r", Branch: "master"}, "r"},
+		{GitInfo{RepoName: "r", Branch: "b",
+			StatusCounts: StatusCounts{Modified: 1}}, "r: b ~1"},
+		{GitInfo{RepoName: "r", Branch: "master", Ahead: 2}, "r ^2"},
+		{GitInfo{RepoName: "r", Branch: "master", Behind: 3}, "r v3"},
+		{GitInfo{RepoName: "r", Branch: "master", Ahead: 2, Behind: 3}, "r ±2/3"},
+		{GitInfo{RepoName: "r", Branch: "b", UpstreamGone: true}, "r: b (gone)"},
+		{GitInfo{RepoName: "r", Branch: "master", Ahead: 1,
+			StatusCounts: StatusCounts{Staged: 1, Untracked: 2}}, "r ^1 +1 ?2"},
+		{GitInfo{RepoName: "r", Branch: "master", StashCount: 2,
+			Worktrees: []Worktree{{Name: "w"}}}, "r #2 &1"},
+		{GitInfo{RepoName: "r", Branch: "b", Tag: "v1.4.2", TagDistance: 3},
+			"r: b v1.4.2+3"},
+		{GitInfo{RepoName: "r", Branch: "master", Tag: "v1", OnTag: true}, "r v1"},
+		{GitInfo{RepoName: "r", Branch: "b", DirtyUnknown: true}, "r: b ?"},
+		{GitInfo{RepoName: "r", Branch: "master", Shallow: true, Partial: true,
+			Ahead: 1}, "r {sh,pc} ^1"},
+		{GitInfo{RepoName: "r@w", Branch: "master", Worktree: "w",
+			Worktrees: []Worktree{{Name: "w"}}}, "r@w"},
+		{GitInfo{RepoName: "r", Branch: "master", Subtree: true,
+			StatusCounts: StatusCounts{Modified: 1}, DirtyElsewhere: true},
+			"r ~1 (*)"},
+		{GitInfo{RepoName: "r", Branch: "master", Subtree: true,
+			DirtyElsewhereUnknown: true}, "r (?)"},
+		{GitInfo{RepoName: "r", Branch: "b", Layout: LayoutBare}, "r: b (bare)"},
+		{GitInfo{RepoName: "r", Branch: "master", Layout: LayoutGitDir},
+			"r (git dir)"},
+		{GitInfo{RepoName: ".dotfiles", Branch: "master", Layout: LayoutEnv,
+			StatusCounts: StatusCounts{Modified: 1}}, ".dotfiles (GIT_DIR) ~1"},
+	}
+	for _, c := range cases {
+		c.info.DefaultBranch = "master"
+		var actual = c.info.String()
+		if actual != c.expected {
+			t.Errorf("Expected \"%s\", got \"%s\"", c.expected, actual)
+		}
+	}
+}
+
+func TestStringDefaultBranch(t *testing.T) {
+	var info = GitInfo{RepoName: "r", Branch: "main", DefaultBranch: "main"}
+	if info.String() != "r" {
+		t.Errorf("Expected \"r\", got \"%s\"", info.String())
+	}
+	info.Branch = "master"
+	if info.String() != "r: master" {
+		t.Errorf("Expected \"r: master\", got \"%s\"", info.String())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var info = GitInfo{RepoName: "r", Branch: "feature/login", Ahead: 1}
+	var actual = info.Format(BranchAbbrev{StripPrefixes: []string{"feature/"}})
+	if actual != "r: login ^1" {
+		t.Errorf("Expected \"r: login ^1\", got \"%s\"", actual)
+	}
+}
+
+func TestStatusSubtree(t *testing.T) {
+	var loc = &repoLocation{RepoPath: "/src/mono"}
+	var opts = Options{SubtreeRepos: []string{"/src/other", "/src/mono/"}}
+	var cases = []struct {
+		pwd      string
+		expected string
+	}{
+		{"/src/mono", ""},
+		{"/src/mono/services/auth", "services/auth"},
+	}
+	for _, c := range cases {
+		var actual = opts.statusSubtree(c.pwd, loc)
+		if actual != c.expected {
+			t.Errorf("%s: expected \"%s\", got \"%s\"", c.pwd, c.expected, actual)
+		}
+	}
+	if actual := (Options{}).statusSubtree("/src/mono/a", loc); actual != "" {
+		t.Errorf("Expected no subtree, got \"%s\"", actual)
+	}
+}
+
+func TestOperationString(t *testing.T) {
+	var info = GitInfo{Operation: OpRebase, OperationStep: 2,
+		OperationTotal: 5, RebaseOnto: "master"}
+	if info.OperationString() != "REBASE 2/5 onto master" {
+		t.Errorf("Expected \"REBASE 2/5 onto master\", got \"%s\"",
+			info.OperationString())
+	}
+	info = GitInfo{Operation: OpMerge}
+	if info.OperationString() != "MERGING" {
+		t.Errorf("Expected \"MERGING\", got \"%s\"", info.OperationString())
+	}
+	info = GitInfo{}
+	if info.OperationString() != "" {
+		t.Errorf("Expected \"\", got \"%s\"", info.OperationString())
+	}
+}
+
+func TestParseDescribe(t *testing.T) {
+	var result = parseDescribe("v1.4.2-3-g0123abc")
+	if result.Tag != "v1.4.2" || result.Distance != 3 {
+		t.Errorf("Expected v1.4.2+3, got %+v", result)
+	}
+	// Tags may contain dashes themselves.
+	result = parseDescribe("release-2-0-g0123abc")
+	if result.Tag != "release-2" || result.Distance != 0 {
+		t.Errorf("Expected release-2+0, got %+v", result)
+	}
+	result = parseDescribe("garbage")
+	if result.Tag != "" {
+		t.Errorf("Expected no tag, got %+v", result)
+	}
+}
+
+func TestDescribeCacheValue(t *testing.T) {
+	var result = describeResult{Tag: "v 1", Distance: 3}
+	var parsed = parseDescribeCacheValue(result.cacheValue())
+	if parsed != result {
+		t.Errorf("Expected %+v, got %+v", result, parsed)
+	}
+	parsed = parseDescribeCacheValue(describeResult{}.cacheValue())
+	if parsed != (describeResult{}) {
+		t.Errorf("Expected no tag, got %+v", parsed)
+	}
+}
+
+func TestAgeString(t *testing.T) {
+	var now = time.Unix(1000000, 0)
+	var info = GitInfo{
+		CommitTime: now.Add(-3 * time.Hour),
+		LastFetch:  now.Add(-50 * time.Hour),
+	}
+	if info.AgeString(now) != "3h, fetched 2d ago" {
+		t.Errorf("Expected \"3h, fetched 2d ago\", got \"%s\"",
+			info.AgeString(now))
+	}
+	info = GitInfo{}
+	if info.AgeString(now) != "" {
+		t.Errorf("Expected \"\", got \"%s\"", info.AgeString(now))
+	}
+}
+
+func TestIsPartialClone(t *testing.T) {
+	var cases = []struct {
+		config   string
+		expected bool
+	}{
+		{"[remote \"origin\"]\nurl = x\n", false},
+		{"[remote \"origin\"]\npromisor = true\n", true},
+		{"[remote \"origin\"]\npromisor = false\n", false},
+		{"[extensions]\npartialClone = origin\n", true},
+	}
+	for _, c := range cases {
+		if isPartialClone(parseConfig(c.config)) != c.expected {
+			t.Errorf("%q: expected %v", c.config, c.expected)
+		}
+	}
+}
+
+func TestBudgetExhausted(t *testing.T) {
+	var b = newBudget(time.Now().Add(-time.Second), time.Millisecond)
+	// No git should run, so even a nonexistent directory times out.
+	if _, err := b.runGit("/nonexistent", "status"); err != util.ErrTimeout {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	_, err := getCommitTime("/nonexistent", "HEAD", b)
+	if err != util.ErrTimeout {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestBatchSSHCommand(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		".git/config": "[core]\n\tsshCommand = ssh -i key\n",
+	})
+	defer os.RemoveAll(dir)
+	defer os.Setenv("GIT_SSH_COMMAND", os.Getenv("GIT_SSH_COMMAND"))
+	os.Setenv("GIT_SSH_COMMAND", "")
+	if actual := batchSSHCommand(dir); actual != "ssh -i key -o BatchMode=yes" {
+		t.Errorf("Unexpected command %q", actual)
+	}
+	os.Setenv("GIT_SSH_COMMAND", "myssh")
+	if actual := batchSSHCommand(dir); actual != "myssh -o BatchMode=yes" {
+		t.Errorf("Unexpected command %q", actual)
+	}
+	os.Setenv("GIT_SSH_COMMAND", "")
+	if actual := batchSSHCommand("/"); actual != "ssh -o BatchMode=yes" {
+		t.Errorf("Unexpected command %q", actual)
+	}
+}
@@ -0,0 +1,134 @@
+// Detection of in-progress Git operations, such as a rebase which has stopped
+// on a conflict.
+package git
+
+import "io/ioutil"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+
+// A multi-step Git operation which may be in progress in a working tree.
+type Operation int
+
+const (
+	OpNone Operation = iota
+	// A rebase with either backend. Since git 2.26, the merge backend marks
+	// every rebase as interactive, so we can't tell "rebase -i" apart.
+	OpRebase
+	// A "git am" session.
+	OpAm
+	// A rebase-apply directory which doesn't say whether it belongs to a rebase
+	// or to "git am".
+	OpAmOrRebase
+	OpMerge
+	OpCherryPick
+	OpRevert
+	OpBisect
+)
+
+// Returns a short name for this Operation, as in git-prompt.sh, or "" for
+// OpNone.
+func (self Operation) String() string {
+	switch self {
+	case OpRebase:
+		return "REBASE"
+	case OpAm:
+		return "AM"
+	case OpAmOrRebase:
+		return "AM/REBASE"
+	case OpMerge:
+		return "MERGING"
+	case OpCherryPick:
+		return "CHERRY-PICKING"
+	case OpRevert:
+		return "REVERTING"
+	case OpBisect:
+		return "BISECTING"
+	}
+	return ""
+}
+
+// Describes the operation in progress in a working tree.
+type operationState struct {
+	Operation Operation
+	// The step we are on and the total number of steps, if known. Zero
+	// otherwise.
+	Step  int
+	Total int
+	// Full name of the branch being rebased (e.g. "refs/heads/feature"), or "".
+	HeadName string
+	// Hash of the commit we are rebasing onto, or "".
+	Onto string
+}
+
+// Inspects the state files in 'gitDir' to determine which operation, if any,
+// is in progress.
+func getOperationState(gitDir string) operationState {
+	var state operationState
+
+	var rebaseMerge = path.Join(gitDir, "rebase-merge")
+	var rebaseApply = path.Join(gitDir, "rebase-apply")
+	if isDir(rebaseMerge) {
+		state.Operation = OpRebase
+		state.Step = readFileInt(path.Join(rebaseMerge, "msgnum"))
+		state.Total = readFileInt(path.Join(rebaseMerge, "end"))
+		state.HeadName = readFileString(path.Join(rebaseMerge, "head-name"))
+		state.Onto = readFileString(path.Join(rebaseMerge, "onto"))
+	} else if isDir(rebaseApply) {
+		if fileExists(path.Join(rebaseApply, "rebasing")) {
+			state.Operation = OpRebase
+			state.HeadName = readFileString(path.Join(rebaseApply, "head-name"))
+			state.Onto = readFileString(path.Join(rebaseApply, "onto"))
+		} else if fileExists(path.Join(rebaseApply, "applying")) {
+			state.Operation = OpAm
+		} else {
+			state.Operation = OpAmOrRebase
+		}
+		state.Step = readFileInt(path.Join(rebaseApply, "next"))
+		state.Total = readFileInt(path.Join(rebaseApply, "last"))
+	} else if fileExists(path.Join(gitDir, "MERGE_HEAD")) {
+		state.Operation = OpMerge
+	} else if fileExists(path.Join(gitDir, "CHERRY_PICK_HEAD")) {
+		state.Operation = OpCherryPick
+	} else if fileExists(path.Join(gitDir, "REVERT_HEAD")) {
+		state.Operation = OpRevert
+	} else if fileExists(path.Join(gitDir, "BISECT_LOG")) {
+		state.Operation = OpBisect
+	}
+
+	// A detached rebase records "detached HEAD" as its head name.
+	if !strings.HasPrefix(state.HeadName, "refs/") {
+		state.HeadName = ""
+	}
+	return state
+}
+
+// Returns the contents of the file at 'p' with surrounding whitespace
+// removed, or "" if it can't be read.
+func readFileString(p string) string {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Returns the integer stored in the file at 'p', or 0 if it can't be read.
+func readFileInt(p string) int {
+	n, err := strconv.Atoi(readFileString(p))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func isDir(p string) bool {
+	fileInfo, err := os.Stat(p)
+	return err == nil && fileInfo.IsDir()
+}
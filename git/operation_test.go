@@ -0,0 +1,123 @@
+package git
+
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+
+// Creates a temporary directory containing the given files. Returns the path
+// to the directory.
+func makeGitDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "git_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		var p = path.Join(dir, name)
+		if err = os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGetOperationStateNone(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{"HEAD": "ref: refs/heads/master"})
+	defer os.RemoveAll(dir)
+	var state = getOperationState(dir)
+	if state.Operation != OpNone {
+		t.Errorf("Expected OpNone, got %v", state.Operation)
+	}
+}
+
+func TestGetOperationStateMergeRebase(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"rebase-merge/interactive": "",
+		"rebase-merge/msgnum":      "2\n",
+		"rebase-merge/end":         "5\n",
+		"rebase-merge/head-name":   "refs/heads/feature\n",
+		"rebase-merge/onto":        "0123456789abcdef\n",
+		"refs/heads/master":        "0123456789abcdef\n",
+	})
+	defer os.RemoveAll(dir)
+	var state = getOperationState(dir)
+	// Every rebase with the merge backend writes "interactive".
+	if state.Operation != OpRebase {
+		t.Errorf("Expected OpRebase, got %v", state.Operation)
+	}
+	if state.Step != 2 || state.Total != 5 {
+		t.Errorf("Expected step 2/5, got %d/%d", state.Step, state.Total)
+	}
+	if state.HeadName != "refs/heads/feature" {
+		t.Errorf("Expected \"refs/heads/feature\", got \"%s\"", state.HeadName)
+	}
+	var onto = findBranchesForHash(dir, state.Onto)
+	if len(onto) != 1 || onto[0] != "master" {
+		t.Errorf("Expected [master], got %v", onto)
+	}
+}
+
+func TestApplyOperationStateOnto(t *testing.T) {
+	var hash = "0123456789abcdef"
+	var config = parseConfig("[branch \"topic\"]\n" +
+		"\tremote = origin\n\tmerge = refs/heads/main\n")
+	var cases = []struct {
+		description string
+		refs        []string
+		headName    string
+		expected    string
+	}{
+		{"only match", []string{"refs/heads/other"}, "", "other"},
+		{"default branch", []string{"refs/heads/feat", "refs/heads/main"}, "",
+			"main"},
+		{"upstream", []string{"refs/heads/feat", "refs/heads/main",
+			"refs/remotes/origin/main"}, "refs/heads/topic", "origin/main"},
+		{"ambiguous", []string{"refs/heads/feat", "refs/heads/feat2"}, "",
+			"0123456"},
+		{"no match", nil, "", "0123456"},
+	}
+	for _, c := range cases {
+		var files = map[string]string{"rebase-merge/onto": hash + "\n"}
+		if c.headName != "" {
+			files["rebase-merge/head-name"] = c.headName + "\n"
+		}
+		for _, ref := range c.refs {
+			files[ref] = hash + "\n"
+		}
+		var dir = makeGitDir(t, files)
+		var info = &GitInfo{DefaultBranch: "main"}
+		info.applyOperationState(getOperationState(dir), dir, config)
+		if info.RebaseOnto != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.description, c.expected,
+				info.RebaseOnto)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetOperationStateAm(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"rebase-apply/applying": "",
+		"rebase-apply/next":     "1",
+		"rebase-apply/last":     "3",
+	})
+	defer os.RemoveAll(dir)
+	var state = getOperationState(dir)
+	if state.Operation != OpAm {
+		t.Errorf("Expected OpAm, got %v", state.Operation)
+	}
+	if state.Step != 1 || state.Total != 3 {
+		t.Errorf("Expected step 1/3, got %d/%d", state.Step, state.Total)
+	}
+}
+
+func TestGetOperationStateMerge(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{"MERGE_HEAD": "0123"})
+	defer os.RemoveAll(dir)
+	if getOperationState(dir).Operation != OpMerge {
+		t.Error("Expected OpMerge")
+	}
+}
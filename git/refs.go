@@ -0,0 +1,132 @@
+// Native lookups of Git refs, which avoid the cost of spawning git.
+package git
+
+import "bufio"
+import "io/ioutil"
+import "os"
+import "path"
+import "sort"
+import "strings"
+
+// Reads the HEAD file in 'gitDir' and returns the name of the branch it
+// points to, or a short hash if it is detached. Returns "" if HEAD can't be
+// read.
+func headBranch(gitDir string) string {
+	var head = readFileString(path.Join(gitDir, "HEAD"))
+	if strings.HasPrefix(head, "ref: ") {
+		return strings.TrimPrefix(head[len("ref: "):], "refs/heads/")
+	}
+	if len(head) > 7 {
+		return head[:7]
+	}
+	return head
+}
+
+// Returns the name of the branch HEAD points to in 'gitDir', or "" if HEAD is
+// detached.
+func localBranch(gitDir string) string {
+	var head = readFileString(path.Join(gitDir, "HEAD"))
+	if !strings.HasPrefix(head, "ref: refs/heads/") {
+		return ""
+	}
+	return head[len("ref: refs/heads/"):]
+}
+
+// Resolves the ref named by HEAD in 'gitDir' to a commit hash. Refs other than
+// HEAD are looked up in 'commonDir'. Returns "" if HEAD can't be resolved (for
+// example, in a repo with no commits).
+func resolveHead(gitDir string, commonDir string) string {
+	var head = readFileString(path.Join(gitDir, "HEAD"))
+	if !strings.HasPrefix(head, "ref: ") {
+		return head
+	}
+	return resolveRef(commonDir, head[len("ref: "):])
+}
+
+// Resolves the full ref name 'ref' (e.g. "refs/heads/master") to a commit
+// hash, using the loose and packed refs in 'commonDir'. Returns "" if there is
+// no such ref.
+func resolveRef(commonDir string, ref string) string {
+	var hash = readFileString(path.Join(commonDir, ref))
+	if hash != "" {
+		return hash
+	}
+
+	file, err := os.Open(path.Join(commonDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// Returns the short names of all branches (local or remote-tracking) which
+// point at 'hash', in alphabetical order.
+func findBranchesForHash(commonDir string, hash string) []string {
+	if hash == "" {
+		return nil
+	}
+	// Maps the full name of each loose ref to its hash. These take precedence
+	// over packed refs of the same name, which may be stale.
+	var loose = make(map[string]string)
+	for _, prefix := range []string{"refs/heads", "refs/remotes"} {
+		readLooseRefs(path.Join(commonDir, prefix), prefix, loose)
+	}
+	var found = make(map[string]bool)
+	for ref, refHash := range loose {
+		if refHash == hash {
+			found[ref] = true
+		}
+	}
+
+	file, err := os.Open(path.Join(commonDir, "packed-refs"))
+	if err == nil {
+		defer file.Close()
+		var scanner = bufio.NewScanner(file)
+		for scanner.Scan() {
+			var fields = strings.Fields(scanner.Text())
+			if len(fields) != 2 || fields[0] != hash {
+				continue
+			}
+			if _, ok := loose[fields[1]]; !ok {
+				found[fields[1]] = true
+			}
+		}
+	}
+
+	var names []string
+	for ref := range found {
+		for _, prefix := range []string{"refs/heads/", "refs/remotes/"} {
+			if strings.HasPrefix(ref, prefix) {
+				names = append(names, ref[len(prefix):])
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Recursively reads the loose refs under 'dir' into 'refs', which maps full
+// ref names to hashes. 'name' is the ref name of 'dir'.
+func readLooseRefs(dir string, name string, refs map[string]string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		var entryName = name + "/" + entry.Name()
+		var entryPath = path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			readLooseRefs(entryPath, entryName, refs)
+		} else if entry.Name() != "HEAD" {
+			refs[entryName] = readFileString(entryPath)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package git
+
+import "os"
+import "strings"
+import "testing"
+
+func TestResolveHeadLoose(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"HEAD":                 "ref: refs/heads/feature/a\n",
+		"refs/heads/feature/a": "0123456789abcdef\n",
+	})
+	defer os.RemoveAll(dir)
+	if headBranch(dir) != "feature/a" {
+		t.Errorf("Expected \"feature/a\", got \"%s\"", headBranch(dir))
+	}
+	if resolveHead(dir, dir) != "0123456789abcdef" {
+		t.Errorf("Expected \"0123456789abcdef\", got \"%s\"",
+			resolveHead(dir, dir))
+	}
+}
+
+func TestResolveHeadPacked(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"HEAD":        "ref: refs/heads/master\n",
+		"packed-refs": "0123456789abcdef refs/heads/master\n",
+	})
+	defer os.RemoveAll(dir)
+	if resolveHead(dir, dir) != "0123456789abcdef" {
+		t.Errorf("Expected \"0123456789abcdef\", got \"%s\"",
+			resolveHead(dir, dir))
+	}
+}
+
+func TestResolveHeadDetached(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{"HEAD": "0123456789abcdef\n"})
+	defer os.RemoveAll(dir)
+	if headBranch(dir) != "0123456" {
+		t.Errorf("Expected \"0123456\", got \"%s\"", headBranch(dir))
+	}
+	if resolveHead(dir, dir) != "0123456789abcdef" {
+		t.Errorf("Expected \"0123456789abcdef\", got \"%s\"",
+			resolveHead(dir, dir))
+	}
+}
+
+func TestFindBranchForHashPacked(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" +
+			"0123 refs/remotes/origin/master\n" +
+			"4567 refs/tags/v1\n",
+	})
+	defer os.RemoveAll(dir)
+	var names = findBranchesForHash(dir, "0123")
+	if len(names) != 1 || names[0] != "origin/master" {
+		t.Errorf("Expected [origin/master], got %v", names)
+	}
+	names = findBranchesForHash(dir, "4567")
+	if len(names) != 0 {
+		t.Errorf("Expected no names, got %v", names)
+	}
+}
+
+func TestFindBranchesForHashLooseOverridesPacked(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"packed-refs":              "0123 refs/heads/moved\n0123 refs/heads/packed\n",
+		"refs/heads/moved":         "4567\n",
+		"refs/heads/feature/a":     "0123\n",
+		"refs/remotes/origin/HEAD": "ref: refs/remotes/origin/master\n",
+	})
+	defer os.RemoveAll(dir)
+	var names = findBranchesForHash(dir, "0123")
+	if strings.Join(names, ",") != "feature/a,packed" {
+		t.Errorf("Expected [feature/a packed], got %v", names)
+	}
+}
@@ -0,0 +1,139 @@
+// Finding a repo's remote and its hosting info.
+package git
+
+import "errors"
+import "os"
+import "path"
+import "sort"
+import "strings"
+import "github.com/sethpollen/sbp-go-utils/hosting"
+
+// Applies the url.<base>.insteadOf rules in 'config' to 'rawURL'. As in Git,
+// the longest matching prefix wins.
+func rewriteURL(config Config, rawURL string) string {
+	var bestPrefix, bestBase string
+	for key, values := range config {
+		if !strings.HasPrefix(key, "url.") ||
+			!strings.HasSuffix(key, ".insteadof") {
+			continue
+		}
+		var base = key[len("url.") : len(key)-len(".insteadof")]
+		for _, prefix := range values {
+			if strings.HasPrefix(rawURL, prefix) &&
+				len(prefix) > len(bestPrefix) {
+				bestPrefix = prefix
+				bestBase = base
+			}
+		}
+	}
+	if bestPrefix == "" {
+		return rawURL
+	}
+	return bestBase + rawURL[len(bestPrefix):]
+}
+
+// Picks the remote to link to: the one the current branch tracks, then
+// "origin", then the first remote in alphabetical order. 'branch' is the
+// current branch name, or "" if HEAD is detached. Returns "" if there are no
+// remotes.
+func pickRemoteName(config Config, branch string) string {
+	if branch != "" {
+		var name = config.Get("branch." + branch + ".remote")
+		if name != "" && name != "." &&
+			config.Get("remote."+name+".url") != "" {
+			return name
+		}
+	}
+	if config.Get("remote.origin.url") != "" {
+		return "origin"
+	}
+	var names []string
+	for key := range config {
+		if strings.HasPrefix(key, "remote.") &&
+			strings.HasSuffix(key, ".url") {
+			names = append(names, key[len("remote."):len(key)-len(".url")])
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// Reads the hosting info for the remote of the repo with Git dir 'gitDir',
+// using 'config' as the repo's config.
+func readRemote(config Config, gitDir string) (*hosting.Remote, error) {
+	var name = pickRemoteName(config, localBranch(gitDir))
+	if name == "" {
+		return nil, errors.New("No remotes")
+	}
+	remote, err := hosting.ParseRemoteURL(
+		rewriteURL(config, config.Get("remote."+name+".url")))
+	if err != nil {
+		return nil, err
+	}
+	remote.Name = name
+	return remote, nil
+}
+
+// Reads the hosting info for the remote of the repo which contains 'pwd',
+// without spawning git.
+func GetRemote(pwd string) (*hosting.Remote, error) {
+	gitDir, commonDir, err := findGitDir(pwd)
+	if err != nil {
+		return nil, err
+	}
+	return readRemote(readRepoConfig(gitDir, commonDir), gitDir)
+}
+
+// Finds the Git dir and common Git dir of the repo which contains 'pwd' by
+// searching upwards for a .git directory or file.
+func findGitDir(pwd string) (string, string, error) {
+	_, gitDir, err := findWorkTree(pwd)
+	if err != nil {
+		return "", "", err
+	}
+	return gitDir, commonGitDir(gitDir), nil
+}
+
+// Finds the root of the working tree which contains 'pwd', and its Git dir,
+// by searching upwards for a .git directory or file.
+func findWorkTree(pwd string) (string, string, error) {
+	for p := path.Clean(pwd); ; p = path.Dir(p) {
+		var dotGit = path.Join(p, ".git")
+		fileInfo, err := os.Stat(dotGit)
+		if err == nil {
+			var gitDir = dotGit
+			if !fileInfo.IsDir() {
+				// A .git file, as found in linked worktrees and submodules, points at
+				// the real Git dir.
+				var contents = readFileString(dotGit)
+				if !strings.HasPrefix(contents, "gitdir: ") {
+					return "", "", errors.New("Malformed .git file: " + dotGit)
+				}
+				gitDir = contents[len("gitdir: "):]
+				if !path.IsAbs(gitDir) {
+					gitDir = path.Join(p, gitDir)
+				}
+			}
+			return p, gitDir, nil
+		}
+		if p == "/" || p == "." {
+			return "", "", errors.New("Not in a Git repo")
+		}
+	}
+}
+
+// Returns the common Git dir for 'gitDir'. Linked worktrees have a commondir
+// file pointing at it; other Git dirs are their own common dir.
+func commonGitDir(gitDir string) string {
+	var commonDir = readFileString(path.Join(gitDir, "commondir"))
+	if commonDir == "" {
+		return gitDir
+	}
+	if !path.IsAbs(commonDir) {
+		commonDir = path.Join(gitDir, commonDir)
+	}
+	return commonDir
+}
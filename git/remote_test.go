@@ -0,0 +1,52 @@
+package git
+
+import "os"
+import "path"
+import "testing"
+
+func TestRewriteURL(t *testing.T) {
+	var config = parseConfig("[url \"git@github.com:\"]\n" +
+		"\tinsteadOf = gh:\n" +
+		"[url \"git@github.com:me/\"]\n" +
+		"\tinsteadOf = gh:me/\n")
+	var actual = rewriteURL(config, "gh:me/repo")
+	if actual != "git@github.com:me/repo" {
+		t.Errorf("Got \"%s\"", actual)
+	}
+	actual = rewriteURL(config, "gh:you/repo")
+	if actual != "git@github.com:you/repo" {
+		t.Errorf("Got \"%s\"", actual)
+	}
+	actual = rewriteURL(config, "https://example.com/a/b")
+	if actual != "https://example.com/a/b" {
+		t.Errorf("Got \"%s\"", actual)
+	}
+}
+
+func TestGetRemote(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		".git/HEAD": "ref: refs/heads/feature\n",
+		".git/config": "[remote \"origin\"]\n" +
+			"\turl = git@github.com:me/repo.git\n" +
+			"[remote \"fork\"]\n" +
+			"\turl = git@gitlab.com:you/repo.git\n" +
+			"[branch \"feature\"]\n" +
+			"\tremote = fork\n",
+		"sub/dir/file": "",
+	})
+	defer os.RemoveAll(dir)
+	// Keep the user's own config out of it.
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("HOME", dir)
+	os.Setenv("XDG_CONFIG_HOME", dir)
+
+	remote, err := GetRemote(path.Join(dir, "sub", "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Name != "fork" || remote.Host != "gitlab.com" ||
+		remote.Owner != "you" {
+		t.Errorf("Got %+v", *remote)
+	}
+}
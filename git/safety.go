@@ -0,0 +1,144 @@
+// Safeguards for running git in directories we don't control. Merely cd-ing
+// into a cloned repo must not let it run commands or interfere with the
+// user's own git commands.
+package git
+
+import "os"
+import "os/exec"
+import "path"
+import "sort"
+import "strings"
+import "syscall"
+
+// Config overrides passed to every git command we run. A repo's config can
+// name an fsmonitor command or hooks, either of which would run arbitrary
+// programs during our queries.
+var safeConfigArgs = []string{
+	"-c", "core.fsmonitor=false",
+	"-c", "core.hooksPath=/dev/null",
+}
+
+// Builds a command which runs git with 'args' in 'pwd', with the safeguards
+// above and those from filterOverrideArgs. GIT_OPTIONAL_LOCKS=0 stops git
+// status from taking index.lock to refresh the index, which would race with
+// the user's own git commands.
+func gitCommand(pwd string, args ...string) *exec.Cmd {
+	var allArgs []string
+	allArgs = append(allArgs, safeConfigArgs...)
+	allArgs = append(allArgs, filterOverrideArgs(pwd)...)
+	allArgs = append(allArgs, args...)
+	var cmd = exec.Command("git", allArgs...)
+	cmd.Dir = pwd
+	cmd.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0")
+	return cmd
+}
+
+// The settings of a filter driver which name commands. git status runs a
+// file's clean filter when it has to re-hash the file.
+var filterCommandKeys = []string{"clean", "smudge", "process", "required"}
+
+// Returns config overrides which stop git from running filter commands
+// defined by the repo containing 'pwd'. Each filter setting in the repo's own
+// config is replaced with the user's global value for it, or otherwise
+// disabled. An empty command disables a filter, but a required filter with no
+// command is an error, so "required" falls back to false.
+func filterOverrideArgs(pwd string) []string {
+	var gitDir, commonDir string
+	if envDir := os.Getenv("GIT_DIR"); envDir != "" {
+		gitDir = envDir
+		if !path.IsAbs(gitDir) {
+			gitDir = path.Join(pwd, gitDir)
+		}
+		commonDir = commonGitDir(gitDir)
+	} else {
+		loc, err := locateRepoNatively(pwd)
+		if err != nil {
+			return nil
+		}
+		gitDir = loc.GitDir
+		commonDir = loc.CommonDir
+	}
+
+	var local = readLocalConfig(gitDir, commonDir)
+	var global Config
+	var keys []string
+	for key := range local {
+		if !strings.HasPrefix(key, "filter.") {
+			continue
+		}
+		var name = key[strings.LastIndex(key, ".")+1:]
+		for _, commandKey := range filterCommandKeys {
+			if name == commandKey {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	global = readGlobalConfig()
+	var args []string
+	for _, key := range keys {
+		var value = global.Get(key)
+		if value == "" && strings.HasSuffix(key, ".required") {
+			value = "false"
+		}
+		args = append(args, "-c", key+"="+value)
+	}
+	return args
+}
+
+// Returns the user ID which owns the file at 'p', or -1 if it can't be
+// determined.
+func fileOwner(p string) int {
+	fileInfo, err := os.Stat(p)
+	if err != nil {
+		return -1
+	}
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1
+	}
+	return int(stat.Uid)
+}
+
+// True iff 'dir' is listed in the safe.directory settings in 'config', which
+// should come from readGlobalConfig only (as in Git, a repo can't vouch for
+// itself). Unlike Git, we ignore settings passed with "git -c" through the
+// environment. An empty value clears the list, "*" matches everything
+// and a value ending in "/*" matches everything under it.
+func isSafeDirectory(config Config, dir string) bool {
+	var safe = false
+	dir = path.Clean(dir)
+	for _, value := range config.GetAll("safe.directory") {
+		switch {
+		case value == "":
+			safe = false
+		case value == "*":
+			safe = true
+		case strings.HasSuffix(value, "/*"):
+			var prefix = path.Clean(strings.TrimSuffix(value, "*"))
+			if strings.HasPrefix(dir+"/", prefix+"/") {
+				safe = true
+			}
+		case path.Clean(value) == dir:
+			safe = true
+		}
+	}
+	return safe
+}
+
+// True iff the repo whose working tree is rooted at 'repoPath' and whose Git
+// dir is 'gitDir' is owned by someone other than the current user and has not
+// been marked safe. We refuse to run git in such repos.
+func isForeignRepo(repoPath string, gitDir string) bool {
+	var uid = os.Getuid()
+	var foreign = false
+	for _, p := range []string{repoPath, gitDir} {
+		if owner := fileOwner(p); owner >= 0 && owner != uid {
+			foreign = true
+		}
+	}
+	return foreign && !isSafeDirectory(readGlobalConfig(), repoPath)
+}
@@ -0,0 +1,202 @@
+package git
+
+import "io/ioutil"
+import "os"
+import "os/exec"
+import "path"
+import "strings"
+import "testing"
+import "time"
+
+func TestGitCommand(t *testing.T) {
+	var cmd = gitCommand("/tmp", "status")
+	var args = strings.Join(cmd.Args, " ")
+	if args != "git -c core.fsmonitor=false -c core.hooksPath=/dev/null status" {
+		t.Errorf("Unexpected args: %s", args)
+	}
+	if cmd.Dir != "/tmp" {
+		t.Errorf("Expected dir /tmp, got %s", cmd.Dir)
+	}
+	if cmd.Env[len(cmd.Env)-1] != "GIT_OPTIONAL_LOCKS=0" {
+		t.Errorf("Expected GIT_OPTIONAL_LOCKS=0, got %v", cmd.Env)
+	}
+}
+
+func TestGitCommandDisablesRepoFilters(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	var repo = makeGitDir(t, map[string]string{"a.txt": "a\n"})
+	defer os.RemoveAll(repo)
+	var run = func(args ...string) {
+		var cmd = exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	run("add", "a.txt")
+	run("-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-m", "a")
+
+	// A repo which runs a command from its clean filter whenever git has to
+	// re-hash a file.
+	var marker = path.Join(repo, "PWN3")
+	var attributes = "* filter=x\n"
+	err := ioutil.WriteFile(path.Join(repo, ".gitattributes"),
+		[]byte(attributes), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := os.OpenFile(path.Join(repo, ".git/config"),
+		os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile.WriteString("[filter \"x\"]\n\tclean = touch " + marker +
+		"; cat\n\trequired = true\n")
+	configFile.Close()
+	var later = time.Now().Add(time.Minute)
+	if err := os.Chtimes(path.Join(repo, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := gitCommand(repo, "status").CombinedOutput(); err != nil {
+		t.Fatalf("git status: %v\n%s", err, out)
+	}
+	if fileExists(marker) {
+		t.Errorf("The repo's clean filter ran")
+	}
+}
+
+func TestIsSafeDirectory(t *testing.T) {
+	var cases = []struct {
+		values   []string
+		dir      string
+		expected bool
+	}{
+		{nil, "/src/r", false},
+		{[]string{"/src/r"}, "/src/r", true},
+		{[]string{"/src/r/"}, "/src/r", true},
+		{[]string{"/src/other"}, "/src/r", false},
+		{[]string{"*"}, "/src/r", true},
+		{[]string{"*", ""}, "/src/r", false},
+		{[]string{"/src/*"}, "/src/r", true},
+		{[]string{"/src/*"}, "/srcs/r", false},
+	}
+	for _, c := range cases {
+		var config = Config{"safe.directory": c.values}
+		if isSafeDirectory(config, c.dir) != c.expected {
+			t.Errorf("%v, %s: expected %v", c.values, c.dir, c.expected)
+		}
+	}
+}
+
+func TestIsForeignRepo(t *testing.T) {
+	var home = makeGitDir(t, nil)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", home)
+
+	var repo = makeGitDir(t, map[string]string{".git/HEAD": "ref: refs/heads/b"})
+	defer os.RemoveAll(repo)
+	var gitDir = path.Join(repo, ".git")
+	if isForeignRepo(repo, gitDir) {
+		t.Errorf("Expected our own repo not to be foreign")
+	}
+
+	// Only root can give files away.
+	if os.Getuid() != 0 {
+		t.Skip("Not running as root")
+	}
+	if err := os.Chown(repo, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if !isForeignRepo(repo, gitDir) {
+		t.Errorf("Expected a repo owned by nobody to be foreign")
+	}
+	var config = "[safe]\n\tdirectory = " + repo + "\n"
+	err := ioutil.WriteFile(path.Join(home, ".gitconfig"), []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isForeignRepo(repo, gitDir) {
+		t.Errorf("Expected safe.directory to override ownership")
+	}
+
+	// Git also reads safe.directory from the system config and from included
+	// files.
+	defer os.Setenv("GIT_CONFIG_SYSTEM", os.Getenv("GIT_CONFIG_SYSTEM"))
+	defer os.Setenv("GIT_CONFIG_NOSYSTEM", os.Getenv("GIT_CONFIG_NOSYSTEM"))
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "")
+	os.Setenv("GIT_CONFIG_SYSTEM", path.Join(home, "system"))
+	err = ioutil.WriteFile(path.Join(home, "system"), []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path.Join(home, ".gitconfig"))
+	if isForeignRepo(repo, gitDir) {
+		t.Errorf("Expected safe.directory in the system config to apply")
+	}
+	os.Remove(path.Join(home, "system"))
+	err = ioutil.WriteFile(path.Join(home, ".gitconfig"),
+		[]byte("[include]\n\tpath = ~/safe\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(home, "safe"), []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isForeignRepo(repo, gitDir) {
+		t.Errorf("Expected safe.directory in an included file to apply")
+	}
+
+	// A repo can't vouch for itself.
+	os.Remove(path.Join(home, "safe"))
+	err = ioutil.WriteFile(path.Join(gitDir, "config"), []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isForeignRepo(repo, gitDir) {
+		t.Errorf("Expected the repo's own safe.directory to be ignored")
+	}
+}
+
+func TestLocateRepoNatively(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"work/.git/HEAD":          "ref: refs/heads/master\n",
+		"work/.git/objects/.keep": "",
+		"work/.git/refs/.keep":    "",
+		"work/sub/.keep":          "",
+		"bare.git/HEAD":           "ref: refs/heads/master\n",
+		"bare.git/config":         "[core]\n\tbare = true\n",
+		"bare.git/objects/.keep":  "",
+		"bare.git/refs/.keep":     "",
+	})
+	defer os.RemoveAll(dir)
+	var cases = []struct {
+		pwd      string
+		repoPath string
+		layout   Layout
+	}{
+		{"work/sub", "work", LayoutWorkTree},
+		{"work/.git/refs", "work/.git", LayoutGitDir},
+		{"bare.git/refs", "bare.git", LayoutBare},
+	}
+	for _, c := range cases {
+		loc, err := locateRepoNatively(path.Join(dir, c.pwd))
+		if err != nil {
+			t.Errorf("%s: %v", c.pwd, err)
+			continue
+		}
+		if loc.RepoPath != path.Join(dir, c.repoPath) || loc.Layout != c.layout {
+			t.Errorf("%s: unexpected location %+v", c.pwd, loc)
+		}
+	}
+	if _, err := locateRepoNatively(dir); err == nil {
+		t.Errorf("Expected no repo outside of the repos")
+	}
+}
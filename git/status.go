@@ -0,0 +1,203 @@
+// Parsing for the output of "git status --porcelain=v2 --branch".
+package git
+
+import "bufio"
+import "fmt"
+import "strconv"
+import "strings"
+
+// Counts of the entries reported by git status, broken down by state.
+type StatusCounts struct {
+	// Entries with changes staged in the index (other than renames).
+	Staged int
+	// Entries renamed or copied in the index.
+	Renamed int
+	// Entries modified in the working tree but not staged.
+	Modified int
+	// Entries deleted from the working tree but not staged.
+	Deleted int
+	// Untracked files.
+	Untracked int
+	// Entries with unresolved merge conflicts.
+	Unmerged int
+	// Submodules whose checked-out commit differs from the one recorded in the
+	// superproject, or which have local changes of their own.
+	Submodules int
+}
+
+// True iff any uncommitted local changes were counted.
+func (self StatusCounts) Dirty() bool {
+	return self != StatusCounts{}
+}
+
+// Formats the nonzero counts as compact indicators, such as "+2 ~3 ?1 !1".
+// Returns "" if there are no changes.
+func (self StatusCounts) String() string {
+	var parts []string
+	var add = func(marker string, count int) {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%s%d", marker, count))
+		}
+	}
+	add("+", self.Staged)
+	add(">", self.Renamed)
+	add("~", self.Modified)
+	add("-", self.Deleted)
+	add("?", self.Untracked)
+	add("!", self.Unmerged)
+	add("@", self.Submodules)
+	return strings.Join(parts, " ")
+}
+
+// A single file entry from git status --porcelain=v2.
+type statusEntry struct {
+	// One of '1' (ordinary change), '2' (rename or copy), 'u' (unmerged) or
+	// '?' (untracked).
+	Kind byte
+	// The two-character XY code giving the staged and unstaged states. Empty
+	// for untracked entries.
+	XY string
+	// The four-character submodule state: "N..." for an ordinary file, or "S"
+	// followed by C (commit changed), M (modified) and U (untracked) flags for
+	// a submodule. Empty for untracked entries.
+	Sub string
+	// Path of the entry, relative to the directory git status ran in.
+	Path string
+}
+
+// The parsed output of git status --porcelain=v2 --branch.
+type statusResult struct {
+	// Hash of the HEAD commit, or "(initial)" in a repo with no commits.
+	Oid string
+	// Name of the current branch, or "(detached)".
+	Head string
+	// Upstream branch, or "" if there is none.
+	Upstream string
+	// True iff the "branch.ab" header was present. Git omits it when the
+	// upstream branch no longer exists.
+	HasAheadBehind bool
+	Ahead          int
+	Behind         int
+	Entries        []statusEntry
+}
+
+// Parses the output of git status --porcelain=v2 --branch.
+func parseStatus(status string) *statusResult {
+	var result = new(statusResult)
+	var scanner = bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		var line = scanner.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			parseStatusHeader(result, line[2:])
+			continue
+		}
+
+		var entry = statusEntry{Kind: line[0]}
+		switch entry.Kind {
+		case '1':
+			// 1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>
+			var fields = strings.SplitN(line, " ", 9)
+			if len(fields) < 9 {
+				continue
+			}
+			entry.XY = fields[1]
+			entry.Sub = fields[2]
+			entry.Path = fields[8]
+		case '2':
+			// 2 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <score> <path>\t<origPath>
+			var fields = strings.SplitN(line, " ", 10)
+			if len(fields) < 10 {
+				continue
+			}
+			entry.XY = fields[1]
+			entry.Sub = fields[2]
+			entry.Path = strings.SplitN(fields[9], "\t", 2)[0]
+		case 'u':
+			// u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>
+			var fields = strings.SplitN(line, " ", 11)
+			if len(fields) < 11 {
+				continue
+			}
+			entry.XY = fields[1]
+			entry.Sub = fields[2]
+			entry.Path = fields[10]
+		case '?':
+			entry.Path = line[2:]
+		default:
+			// Ignored files and anything we don't understand.
+			continue
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result
+}
+
+// Parses a single "# branch.*" header line, with the leading "# " removed.
+func parseStatusHeader(result *statusResult, header string) {
+	var fields = strings.Fields(header)
+	if len(fields) < 2 {
+		return
+	}
+	switch fields[0] {
+	case "branch.oid":
+		result.Oid = fields[1]
+	case "branch.head":
+		result.Head = fields[1]
+	case "branch.upstream":
+		result.Upstream = fields[1]
+	case "branch.ab":
+		if len(fields) < 3 {
+			return
+		}
+		ahead, aheadErr := strconv.Atoi(strings.TrimPrefix(fields[1], "+"))
+		behind, behindErr := strconv.Atoi(strings.TrimPrefix(fields[2], "-"))
+		if aheadErr != nil || behindErr != nil {
+			return
+		}
+		result.HasAheadBehind = true
+		result.Ahead = ahead
+		result.Behind = behind
+	}
+}
+
+// Tallies up the states of 'entries'.
+func countEntries(entries []statusEntry) StatusCounts {
+	var counts StatusCounts
+	for _, entry := range entries {
+		switch entry.Kind {
+		case '?':
+			counts.Untracked++
+			continue
+		case 'u':
+			counts.Unmerged++
+			continue
+		}
+
+		if len(entry.XY) != 2 {
+			continue
+		}
+		if entry.Kind == '2' {
+			counts.Renamed++
+		} else if entry.XY[0] != '.' {
+			counts.Staged++
+		}
+		if strings.HasPrefix(entry.Sub, "S") {
+			// Unstaged changes to a submodule are counted separately from changes
+			// to ordinary files.
+			if entry.Sub != "S..." {
+				counts.Submodules++
+			}
+			continue
+		}
+		switch entry.XY[1] {
+		case 'M', 'T':
+			counts.Modified++
+		case 'D':
+			counts.Deleted++
+		}
+	}
+	return counts
+}
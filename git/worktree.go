@@ -0,0 +1,73 @@
+// Native queries for a repo's linked worktrees and stashes.
+package git
+
+import "bufio"
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+
+// A linked worktree, as created by "git worktree add".
+type Worktree struct {
+	// Name of the worktree's administrative directory under .git/worktrees.
+	// This is usually the basename of Path.
+	Name string
+	// Path to the root of the worktree.
+	Path string
+	// The branch checked out in the worktree, or a short hash if it has a
+	// detached head.
+	Branch string
+}
+
+// Lists the linked worktrees of the repo whose common Git dir is 'commonDir'.
+// The main worktree is not included.
+func listWorktrees(commonDir string) []Worktree {
+	var adminDir = path.Join(commonDir, "worktrees")
+	entries, err := ioutil.ReadDir(adminDir)
+	if err != nil {
+		return nil
+	}
+	var worktrees []Worktree
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var dir = path.Join(adminDir, entry.Name())
+		var worktree = Worktree{Name: entry.Name()}
+		// The gitdir file points at the .git file in the worktree root.
+		var gitFile = readFileString(path.Join(dir, "gitdir"))
+		if gitFile != "" {
+			worktree.Path = path.Dir(gitFile)
+		}
+		worktree.Branch = headBranch(dir)
+		worktrees = append(worktrees, worktree)
+	}
+	return worktrees
+}
+
+// Returns the name of the main repo whose common Git dir is 'commonDir'.
+func mainRepoName(commonDir string) string {
+	if path.Base(commonDir) == ".git" {
+		return path.Base(path.Dir(commonDir))
+	}
+	// A bare repo.
+	return strings.TrimSuffix(path.Base(commonDir), ".git")
+}
+
+// Counts the stashes in the repo whose common Git dir is 'commonDir'. Each
+// stash is an entry in the reflog of refs/stash.
+func countStashes(commonDir string) int {
+	file, err := os.Open(path.Join(commonDir, "logs", "refs", "stash"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	var count = 0
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			count++
+		}
+	}
+	return count
+}
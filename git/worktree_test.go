@@ -0,0 +1,56 @@
+package git
+
+import "os"
+import "path"
+import "testing"
+
+func TestListWorktrees(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"worktrees/a/gitdir": "/src/a/.git\n",
+		"worktrees/a/HEAD":   "ref: refs/heads/feature/a\n",
+		"worktrees/b/gitdir": "/src/b/.git\n",
+		"worktrees/b/HEAD":   "0123456789abcdef\n",
+	})
+	defer os.RemoveAll(dir)
+	var worktrees = listWorktrees(dir)
+	var expected = []Worktree{
+		{Name: "a", Path: "/src/a", Branch: "feature/a"},
+		{Name: "b", Path: "/src/b", Branch: "0123456"},
+	}
+	if len(worktrees) != len(expected) {
+		t.Fatalf("Expected %d worktrees, got %d", len(expected), len(worktrees))
+	}
+	for i := range expected {
+		if worktrees[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], worktrees[i])
+		}
+	}
+}
+
+func TestListWorktreesNone(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	if len(listWorktrees(dir)) != 0 {
+		t.Error("Expected no worktrees")
+	}
+}
+
+func TestCountStashes(t *testing.T) {
+	var dir = makeGitDir(t, map[string]string{
+		"logs/refs/stash": "0000 1111 a <a@b> 1 +0000\tWIP on master\n" +
+			"1111 2222 a <a@b> 2 +0000\tWIP on master\n",
+	})
+	defer os.RemoveAll(dir)
+	if countStashes(dir) != 2 {
+		t.Errorf("Expected 2, got %d", countStashes(dir))
+	}
+}
+
+func TestMainRepoName(t *testing.T) {
+	if mainRepoName("/src/repo/.git") != "repo" {
+		t.Errorf("Expected \"repo\", got \"%s\"", mainRepoName("/src/repo/.git"))
+	}
+	if mainRepoName(path.Join("/src", "bare.git")) != "bare" {
+		t.Errorf("Expected \"bare\", got \"%s\"", mainRepoName("/src/bare.git"))
+	}
+}
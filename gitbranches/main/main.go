@@ -0,0 +1,55 @@
+// Lists the local branches of the Git repo containing the current directory,
+// with how far each is ahead of and behind its upstream.
+package main
+
+import "flag"
+import "fmt"
+import "os"
+import "text/tabwriter"
+import "time"
+import "github.com/sethpollen/sbp-go-utils/git"
+import "github.com/sethpollen/sbp-go-utils/util"
+
+var unpushedOnly = flag.Bool("unpushed", false,
+	"If true, only list branches with commits which have not been pushed.")
+
+func main() {
+	flag.Parse()
+	var pwd = os.Getenv("PWD")
+	if pwd == "" {
+		pwd, _ = os.Getwd()
+	}
+	branches, err := git.ListBranches(pwd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+		return
+	}
+	var now = time.Now()
+	var out = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, branch := range branches {
+		if *unpushedOnly && branch.Ahead == 0 {
+			continue
+		}
+		var current = " "
+		if branch.Current {
+			current = "*"
+		}
+		var track string
+		switch {
+		case branch.Upstream == "":
+			track = "-"
+		case branch.UpstreamGone:
+			track = "gone"
+		default:
+			track = fmt.Sprintf("^%d v%d", branch.Ahead, branch.Behind)
+		}
+		var age = "-"
+		if !branch.CommitTime.IsZero() {
+			age = util.ShortDuration(now.Sub(branch.CommitTime))
+		}
+		fmt.Fprintf(out, "%s %s\t%s\t%s\t%s\n", current, branch.Name,
+			branch.Upstream, track, age)
+	}
+	out.Flush()
+}
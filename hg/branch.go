@@ -0,0 +1,107 @@
+// Native queries for the branch, bookmarks and topic of an Hg working
+// directory.
+package hg
+
+import "bufio"
+import "encoding/hex"
+import "io/ioutil"
+import "os"
+import "path"
+import "sort"
+import "strings"
+
+// The branch a working directory is on when .hg/branch doesn't say.
+const defaultBranch = "default"
+
+// Returns the contents of the file at 'p' with surrounding whitespace
+// removed, or "" if it can't be read.
+func readFileString(p string) string {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Returns the name of the branch the working directory of the repo at
+// 'repoPath' is on.
+func readBranch(repoPath string) string {
+	var branch = readFileString(path.Join(repoPath, ".hg", "branch"))
+	if branch == "" {
+		return defaultBranch
+	}
+	return branch
+}
+
+// Returns the active bookmark of the repo at 'repoPath', or "" if there is
+// none.
+func readActiveBookmark(repoPath string) string {
+	return readFileString(path.Join(repoPath, ".hg", "bookmarks.current"))
+}
+
+// Returns the current topic (from the topic extension) of the repo at
+// 'repoPath', or "" if there is none.
+func readTopic(repoPath string) string {
+	return readFileString(path.Join(repoPath, ".hg", "topic"))
+}
+
+// Reads the bookmarks of the repo at 'repoPath', as a map from each
+// bookmark's name to the hex hash of the changeset it points at.
+func readBookmarks(repoPath string) map[string]string {
+	var bookmarks = make(map[string]string)
+	var metaDir = path.Join(repoPath, ".hg")
+	if sharedPath := readSharedPath(repoPath); sharedPath != "" &&
+		sharesBookmarks(repoPath) {
+		metaDir = sharedPath
+	}
+	// Repos with the bookmarksinstore requirement keep them in the store.
+	file, err := os.Open(path.Join(metaDir, "bookmarks"))
+	if os.IsNotExist(err) {
+		file, err = os.Open(path.Join(storePath(repoPath), "bookmarks"))
+	}
+	if err != nil {
+		return bookmarks
+	}
+	defer file.Close()
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var fields = strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) == 2 && fields[1] != "" {
+			bookmarks[fields[1]] = fields[0]
+		}
+	}
+	return bookmarks
+}
+
+// Returns the names of the bookmarks in 'bookmarks' which point at 'hash',
+// in alphabetical order.
+func bookmarksAt(bookmarks map[string]string, hash string) []string {
+	var names []string
+	for name, target := range bookmarks {
+		if target == hash {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the hex hash of the working directory's first parent in the repo
+// at 'repoPath', or "" if it can't be read. Both dirstate formats start with
+// the parents, though version 2 puts a marker before them.
+func readWorkingParent(repoPath string) string {
+	var offset = 0
+	if usesDirstateV2(repoPath) {
+		offset = len(dirstateV2Marker)
+	}
+	file, err := os.Open(path.Join(repoPath, ".hg", "dirstate"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	var parent = make([]byte, 20)
+	if _, err = file.ReadAt(parent, int64(offset)); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(parent)
+}
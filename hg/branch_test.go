@@ -0,0 +1,115 @@
+package hg
+
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+import "testing"
+
+// Creates an Hg repo whose .hg directory contains 'files'. Returns the repo's
+// root.
+func makeHgDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "hg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		var p = path.Join(dir, ".hg", name)
+		if err = os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadBranchDefault(t *testing.T) {
+	var dir = makeHgDir(t, nil)
+	defer os.RemoveAll(dir)
+	if branch := readBranch(dir); branch != "default" {
+		t.Errorf("Expected \"default\", got \"%s\"", branch)
+	}
+	if readActiveBookmark(dir) != "" || readTopic(dir) != "" {
+		t.Errorf("Expected no bookmark or topic")
+	}
+}
+
+func TestReadBranchBookmarkTopic(t *testing.T) {
+	var dir = makeHgDir(t, map[string]string{
+		"branch":            "stable\n",
+		"bookmarks.current": "feature",
+		"topic":             "login\n",
+	})
+	defer os.RemoveAll(dir)
+	if branch := readBranch(dir); branch != "stable" {
+		t.Errorf("Expected \"stable\", got \"%s\"", branch)
+	}
+	if bookmark := readActiveBookmark(dir); bookmark != "feature" {
+		t.Errorf("Expected \"feature\", got \"%s\"", bookmark)
+	}
+	if topic := readTopic(dir); topic != "login" {
+		t.Errorf("Expected \"login\", got \"%s\"", topic)
+	}
+}
+
+func TestBookmarksAtWorkingParent(t *testing.T) {
+	var parent = strings.Repeat("ab", 20)
+	var other = strings.Repeat("cd", 20)
+	var dir = makeHgDir(t, map[string]string{
+		"bookmarks": parent + " zeta\n" + other + " elsewhere\n" +
+			parent + " alpha with spaces\n",
+		"dirstate": string(encodeDirstate(&dirstate{
+			Parent1: parent, Parent2: nullHash})),
+	})
+	defer os.RemoveAll(dir)
+	var bookmarks = bookmarksAt(readBookmarks(dir), readWorkingParent(dir))
+	if strings.Join(bookmarks, ",") != "alpha with spaces,zeta" {
+		t.Errorf("Expected [alpha with spaces zeta], got %v", bookmarks)
+	}
+}
+
+func TestReadBookmarksInStore(t *testing.T) {
+	var dir = makeHgDir(t, map[string]string{
+		"store/bookmarks": strings.Repeat("ab", 20) + " main\n",
+	})
+	defer os.RemoveAll(dir)
+	if len(readBookmarks(dir)) != 1 {
+		t.Errorf("Expected one bookmark, got %v", readBookmarks(dir))
+	}
+}
+
+func TestReadWorkingParentV2(t *testing.T) {
+	var parent = strings.Repeat("ef", 20)
+	var hash = encodeDirstate(&dirstate{Parent1: parent, Parent2: nullHash})
+	var dir = makeHgDir(t, map[string]string{
+		"requires": "dirstate-v2\n",
+		"dirstate": dirstateV2Marker + string(hash[:20]) +
+			strings.Repeat("\x00", 44),
+	})
+	defer os.RemoveAll(dir)
+	if actual := readWorkingParent(dir); actual != parent {
+		t.Errorf("Expected %s, got %s", parent, actual)
+	}
+}
+
+func TestString(t *testing.T) {
+	var cases = []struct {
+		info     HgInfo
+		expected string
+	}{
+		{HgInfo{RepoName: "r", Branch: "default"}, "r"},
+		{HgInfo{RepoName: "r", Branch: "stable", Dirty: true}, "r: stable *"},
+		{HgInfo{RepoName: "r", Branch: "stable", ActiveBookmark: "feature"},
+			"r: feature"},
+		{HgInfo{RepoName: "r", Branch: "default", Topic: "login"}, "r: //login"},
+		{HgInfo{RepoName: "r", Branch: "stable", Topic: "login"},
+			"r: stable//login"},
+	}
+	for _, c := range cases {
+		if actual := c.info.String(); actual != c.expected {
+			t.Errorf("Expected \"%s\", got \"%s\"", c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// A client for Mercurial's command server, which keeps one hg process running
+// so that repeated queries don't each pay for Python's startup.
+package hg
+
+import "bufio"
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+import "os/exec"
+import "strings"
+import "sync"
+import "github.com/sethpollen/sbp-go-utils/util"
+
+// A running "hg serve --cmdserver pipe" process. Its methods may be called
+// from multiple goroutines; commands run one at a time.
+type CommandServer struct {
+	// What the server said it can do, such as "runcommand".
+	Capabilities []string
+	// The encoding the server uses for output.
+	Encoding string
+
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	input  io.WriteCloser
+	output *bufio.Reader
+	// For a server started by LazyCommandServer, the repo to serve, and the
+	// error from starting the server (once we have tried).
+	repoPath string
+	startErr error
+}
+
+// The result of running a command with a CommandServer.
+type CommandResult struct {
+	// What the command wrote to its output and error channels.
+	Output string
+	Error  string
+	// The command's return code.
+	Code int
+}
+
+// Starts a command server for the repo at 'repoPath'. The caller must Close
+// it when done.
+func StartCommandServer(repoPath string) (*CommandServer, error) {
+	var server = LazyCommandServer(repoPath)
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Returns a command server for the repo at 'repoPath' which doesn't start hg
+// until it is first needed, so that it costs nothing if it is never used. The
+// caller must Close it when done.
+func LazyCommandServer(repoPath string) *CommandServer {
+	return &CommandServer{repoPath: repoPath}
+}
+
+// Starts the server if it hasn't been started yet. Returns the error from
+// starting it, which is remembered, so that we don't keep trying.
+func (self *CommandServer) Start() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.start()
+}
+
+// Does the work of Start. The caller must hold the mutex.
+func (self *CommandServer) start() error {
+	if self.input != nil || self.startErr != nil {
+		return self.startErr
+	}
+	self.startErr = self.connect()
+	return self.startErr
+}
+
+// Starts hg and reads its hello message.
+func (self *CommandServer) connect() error {
+	var cmd = exec.Command("hg", "serve", "--cmdserver", "pipe",
+		"--config", "ui.interactive=false")
+	cmd.Dir = self.repoPath
+	// Keep the user's aliases and output customizations out of our results.
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	self.input = input
+	self.output = bufio.NewReader(output)
+	if err = self.readHello(); err != nil {
+		input.Close()
+		cmd.Wait()
+		self.input = nil
+		return err
+	}
+	self.cmd = cmd
+	return nil
+}
+
+// Connects to a command server which reads from 'input' and writes to
+// 'output', and reads its hello message.
+func newCommandServer(input io.WriteCloser,
+	output io.Reader) (*CommandServer, error) {
+	var server = &CommandServer{input: input, output: bufio.NewReader(output)}
+	if err := server.readHello(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Reads the hello message the server sends when it starts.
+func (self *CommandServer) readHello() error {
+	channel, data, err := self.readMessage()
+	if err != nil {
+		return err
+	}
+	if channel != 'o' {
+		return fmt.Errorf("Expected hello on channel o, got %c", channel)
+	}
+	// The hello message is a series of "field: value" lines.
+	for _, line := range strings.Split(string(data), "\n") {
+		var fields = strings.SplitN(line, ": ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "capabilities":
+			self.Capabilities = strings.Fields(fields[1])
+		case "encoding":
+			self.Encoding = fields[1]
+		}
+	}
+	if !self.hasCapability("runcommand") {
+		return errors.New("Command server can't run commands")
+	}
+	return nil
+}
+
+func (self *CommandServer) hasCapability(name string) bool {
+	for _, capability := range self.Capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads one message from the server. Each message is a channel byte, a
+// big-endian 32-bit length, and (for output channels) that many bytes of
+// data. Input channels (uppercase) send no data; the length is how much
+// input the server wants.
+func (self *CommandServer) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(self.output, header[:]); err != nil {
+		return 0, nil, err
+	}
+	var channel = header[0]
+	var length = binary.BigEndian.Uint32(header[1:])
+	if channel >= 'A' && channel <= 'Z' {
+		return channel, nil, nil
+	}
+	var data = make([]byte, length)
+	if _, err := io.ReadFull(self.output, data); err != nil {
+		return 0, nil, err
+	}
+	return channel, data, nil
+}
+
+// Runs the hg command with arguments 'args' (e.g. "status", "-q") on the
+// server. A non-zero return code is not an error; check the result's Code.
+func (self *CommandServer) RunCommand(args ...string) (*CommandResult,
+	error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err := self.start(); err != nil {
+		return nil, err
+	}
+
+	var request bytes.Buffer
+	var encodedArgs = strings.Join(args, "\x00")
+	request.WriteString("runcommand\n")
+	binary.Write(&request, binary.BigEndian, uint32(len(encodedArgs)))
+	request.WriteString(encodedArgs)
+	if _, err := self.input.Write(request.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var output, errOutput bytes.Buffer
+	for {
+		channel, data, err := self.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch channel {
+		case 'o':
+			output.Write(data)
+		case 'e':
+			errOutput.Write(data)
+		case 'r':
+			if len(data) != 4 {
+				return nil, errors.New("Malformed result from command server")
+			}
+			return &CommandResult{
+				Output: output.String(),
+				Error:  errOutput.String(),
+				Code:   int(int32(binary.BigEndian.Uint32(data))),
+			}, nil
+		case 'I', 'L':
+			// The command wants input, which we never have. A zero-length reply
+			// means end of file.
+			if _, err = self.input.Write([]byte{0, 0, 0, 0}); err != nil {
+				return nil, err
+			}
+		default:
+			// Unknown output channels may be ignored, but the protocol says we
+			// must not continue after an unknown input channel.
+			if channel >= 'A' && channel <= 'Z' {
+				return nil, fmt.Errorf("Unexpected command server channel %c",
+					channel)
+			}
+		}
+	}
+}
+
+// Runs the hg command with arguments 'args' and returns its output with
+// surrounding whitespace removed. Unlike RunCommand, treats a non-zero return
+// code as an error.
+func (self *CommandServer) Eval(args ...string) (string, error) {
+	result, err := self.RunCommand(args...)
+	if err != nil {
+		return "", err
+	}
+	if result.Code != 0 {
+		return "", fmt.Errorf("hg %s failed (%d): %s", strings.Join(args, " "),
+			result.Code, strings.TrimSpace(result.Error))
+	}
+	return strings.TrimSpace(result.Output), nil
+}
+
+// Shuts down the server, if it was started, and waits for it to exit.
+func (self *CommandServer) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.input == nil {
+		return nil
+	}
+	var err = self.input.Close()
+	if self.cmd != nil {
+		if waitErr := self.cmd.Wait(); err == nil {
+			err = waitErr
+		}
+	}
+	return err
+}
+
+// Runs hg with 'args' in 'pwd', using 'server' if it is not nil and can be
+// started. Returns the output with surrounding whitespace removed.
+func runHg(server *CommandServer, pwd string, args ...string) (string,
+	error) {
+	if server != nil && server.Start() == nil {
+		return server.Eval(args...)
+	}
+	return util.EvalCommandSync(pwd, "hg", args...)
+}
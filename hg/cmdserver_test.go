@@ -0,0 +1,205 @@
+package hg
+
+import "bufio"
+import "encoding/binary"
+import "io"
+import "io/ioutil"
+import "os"
+import "os/exec"
+import "strings"
+import "testing"
+
+// One step of a scripted fake command server: the arguments it expects, and
+// the messages it sends back.
+type fakeExchange struct {
+	args     []string
+	messages []fakeMessage
+}
+
+type fakeMessage struct {
+	channel byte
+	data    string
+	// For input channels, the length to request.
+	length uint32
+}
+
+// Writes one command server message to 'w'.
+func writeMessage(w io.Writer, message fakeMessage) {
+	var header [5]byte
+	header[0] = message.channel
+	if message.channel >= 'A' && message.channel <= 'Z' {
+		binary.BigEndian.PutUint32(header[1:], message.length)
+		w.Write(header[:])
+		return
+	}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message.data)))
+	w.Write(header[:])
+	io.WriteString(w, message.data)
+}
+
+// Encodes a return code for the 'r' channel.
+func resultData(code int32) string {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(code))
+	return string(data[:])
+}
+
+// Starts a fake command server which sends 'hello' and then plays 'script',
+// reporting protocol violations to 't'. Returns a client connected to it.
+func startFakeServer(t *testing.T, hello string,
+	script []fakeExchange) (*CommandServer, error) {
+	// The server reads what the client writes, and vice versa.
+	serverInput, clientInput := io.Pipe()
+	clientOutput, serverOutput := io.Pipe()
+	go func() {
+		defer serverOutput.Close()
+		writeMessage(serverOutput, fakeMessage{channel: 'o', data: hello})
+		var reader = bufio.NewReader(serverInput)
+		for _, exchange := range script {
+			command, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if command != "runcommand\n" {
+				t.Errorf("Expected runcommand, got %q", command)
+				return
+			}
+			var length uint32
+			binary.Read(reader, binary.BigEndian, &length)
+			var args = make([]byte, length)
+			io.ReadFull(reader, args)
+			if string(args) != strings.Join(exchange.args, "\x00") {
+				t.Errorf("Expected args %q, got %q", exchange.args, args)
+			}
+			for _, message := range exchange.messages {
+				writeMessage(serverOutput, message)
+				if message.channel == 'I' || message.channel == 'L' {
+					// The client should answer with end of file.
+					binary.Read(reader, binary.BigEndian, &length)
+					if length != 0 {
+						t.Errorf("Expected an empty input reply, got %d", length)
+					}
+				}
+			}
+		}
+	}()
+	return newCommandServer(clientInput, clientOutput)
+}
+
+const fakeHello = "capabilities: getencoding runcommand\nencoding: UTF-8\n" +
+	"pid: 42"
+
+func TestCommandServerFake(t *testing.T) {
+	server, err := startFakeServer(t, fakeHello, []fakeExchange{
+		{[]string{"status"}, []fakeMessage{
+			{channel: 'o', data: "M a.txt\n"},
+			{channel: 'd', data: "ignored debug output"},
+			{channel: 'o', data: "? b.txt\n"},
+			{channel: 'r', data: resultData(0)},
+		}},
+		{[]string{"log", "-r", "nonsense"}, []fakeMessage{
+			{channel: 'e', data: "abort: unknown revision 'nonsense'\n"},
+			{channel: 'r', data: resultData(255)},
+		}},
+		{[]string{"commit"}, []fakeMessage{
+			{channel: 'L', length: 4096},
+			{channel: 'r', data: resultData(1)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if server.Encoding != "UTF-8" || !server.hasCapability("getencoding") {
+		t.Errorf("Unexpected hello: %v, %s", server.Capabilities,
+			server.Encoding)
+	}
+
+	output, err := server.Eval("status")
+	if output != "M a.txt\n? b.txt" || err != nil {
+		t.Errorf("Unexpected status: %q, %v", output, err)
+	}
+	result, err := server.RunCommand("log", "-r", "nonsense")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != 255 || !strings.Contains(result.Error, "abort") {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if _, err = server.Eval("commit"); err == nil {
+		t.Errorf("Expected an error for a non-zero return code")
+	}
+}
+
+func TestCommandServerNoRunCommand(t *testing.T) {
+	_, err := startFakeServer(t, "capabilities: getencoding\n", nil)
+	if err == nil {
+		t.Errorf("Expected an error without the runcommand capability")
+	}
+}
+
+func TestCommandServerUnknownInputChannel(t *testing.T) {
+	server, err := startFakeServer(t, fakeHello, []fakeExchange{
+		{[]string{"version"}, []fakeMessage{{channel: 'X', length: 1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if _, err = server.RunCommand("version"); err == nil {
+		t.Errorf("Expected an error for an unknown input channel")
+	}
+}
+
+func TestCommandServerRealHg(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg is not installed")
+	}
+	dir, err := ioutil.TempDir("", "hg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = exec.Command("hg", "init", dir).Run(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(dir+"/new.txt", []byte("new"), 0644)
+
+	server, err := StartCommandServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	// Run several commands on the same server.
+	for i := 0; i < 3; i++ {
+		output, err := server.Eval("status")
+		if output != "? new.txt" || err != nil {
+			t.Errorf("Unexpected status: %q, %v", output, err)
+		}
+	}
+	result, err := server.RunCommand("log", "-r", "nonsense")
+	if err != nil || result.Code == 0 {
+		t.Errorf("Expected a failing command, got %+v, %v", result, err)
+	}
+}
+
+func TestLazyCommandServer(t *testing.T) {
+	// Nothing starts until the server is needed.
+	var server = LazyCommandServer("/nonexistent")
+	if server.cmd != nil || server.Close() != nil {
+		t.Errorf("Expected an unused server not to start")
+	}
+
+	server = LazyCommandServer("/nonexistent")
+	defer server.Close()
+	var err = server.Start()
+	if err == nil {
+		t.Fatalf("Expected an error starting hg in a missing directory")
+	}
+	if server.Start() != err {
+		t.Errorf("Expected the error to be remembered")
+	}
+	if _, err = server.RunCommand("status"); err == nil {
+		t.Errorf("Expected an error running a command")
+	}
+}
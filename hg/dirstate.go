@@ -0,0 +1,214 @@
+// A native reader for Mercurial's dirstate, which records the state of every
+// tracked file as of the last time hg looked at it. Comparing that with the
+// working tree tells us whether it is dirty without running hg.
+package hg
+
+import "bufio"
+import "bytes"
+import "encoding/binary"
+import "encoding/hex"
+import "errors"
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+
+// Returned when the dirstate can't tell us whether the working tree is dirty,
+// so we have to ask hg.
+var errAmbiguous = errors.New("Dirstate is ambiguous")
+
+// Length of the dirstate header, which holds the two parent hashes.
+const dirstateHeaderLength = 40
+
+// Length of the fixed-size part of each dirstate entry: a state byte, then
+// the mode, size, mtime and name length as big-endian 32-bit integers.
+const dirstateEntryLength = 17
+
+// The start of a version 2 dirstate file, which is a docket pointing at the
+// real data.
+const dirstateV2Marker = "dirstate-v2\n"
+
+// The null revision's hash, used as the second parent outside of merges.
+var nullHash = strings.Repeat("0", 40)
+
+// One tracked file in the dirstate.
+type dirstateEntry struct {
+	// 'n' (normal), 'a' (added), 'r' (removed) or 'm' (merged).
+	State byte
+	Mode  uint32
+	// The file's size when hg last looked at it. -1 means hg must look again,
+	// and -2 means the file came from the other parent of a merge.
+	Size int32
+	// The file's mtime, in seconds, when hg last looked at it. -1 means hg
+	// must look again.
+	Mtime int32
+	// Path of the file, relative to the repo root.
+	Name string
+	// If the file was copied or renamed, the path it came from. Otherwise "".
+	CopySource string
+}
+
+// The parsed contents of a version 1 dirstate file.
+type dirstate struct {
+	// Hex hashes of the working directory's parents. Parent2 is nullHash
+	// unless a merge is in progress.
+	Parent1 string
+	Parent2 string
+	Entries []dirstateEntry
+}
+
+// Parses the contents of a version 1 dirstate file.
+func parseDirstate(data []byte) (*dirstate, error) {
+	if len(data) < dirstateHeaderLength {
+		return nil, errors.New("Dirstate is too short")
+	}
+	var ds = new(dirstate)
+	ds.Parent1 = hex.EncodeToString(data[:20])
+	ds.Parent2 = hex.EncodeToString(data[20:40])
+	for pos := dirstateHeaderLength; pos < len(data); {
+		if pos+dirstateEntryLength > len(data) {
+			return nil, errors.New("Truncated dirstate entry")
+		}
+		var header = data[pos : pos+dirstateEntryLength]
+		var entry dirstateEntry
+		entry.State = header[0]
+		entry.Mode = binary.BigEndian.Uint32(header[1:])
+		entry.Size = int32(binary.BigEndian.Uint32(header[5:]))
+		entry.Mtime = int32(binary.BigEndian.Uint32(header[9:]))
+		var nameLength = int(binary.BigEndian.Uint32(header[13:]))
+		pos += dirstateEntryLength
+		if nameLength < 0 || pos+nameLength > len(data) {
+			return nil, errors.New("Truncated dirstate entry")
+		}
+		var name = data[pos : pos+nameLength]
+		pos += nameLength
+		// A copy source follows the name, separated by a NUL.
+		if nul := bytes.IndexByte(name, 0); nul >= 0 {
+			entry.CopySource = string(name[nul+1:])
+			name = name[:nul]
+		}
+		entry.Name = string(name)
+		ds.Entries = append(ds.Entries, entry)
+	}
+	return ds, nil
+}
+
+// True iff the repo at 'repoPath' uses version 2 of the dirstate format,
+// which we don't parse.
+func usesDirstateV2(repoPath string) bool {
+	file, err := os.Open(path.Join(repoPath, ".hg", "requires"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "dirstate-v2" {
+			return true
+		}
+	}
+	return false
+}
+
+// Determines from the dirstate whether the working tree of the repo at
+// 'repoPath' has modified, added, removed or missing files. Untracked files
+// are not considered. Returns errAmbiguous (or another error) if only hg can
+// tell.
+func dirstateDirty(repoPath string) (bool, error) {
+	if usesDirstateV2(repoPath) {
+		return false, errAmbiguous
+	}
+	var dirstatePath = path.Join(repoPath, ".hg", "dirstate")
+	dirstateInfo, err := os.Stat(dirstatePath)
+	if os.IsNotExist(err) {
+		// Nothing has been tracked yet.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(dirstatePath)
+	if err != nil {
+		return false, err
+	}
+	ds, err := parseDirstate(data)
+	if err != nil {
+		return false, err
+	}
+	// Files modified in the same second as the dirstate was written could have
+	// changed without changing their recorded mtime.
+	var dirstateMtime = int32(dirstateInfo.ModTime().Unix() & 0x7fffffff)
+	return ds.dirty(repoPath, dirstateMtime)
+}
+
+// Does the work of dirstateDirty once the dirstate has been read.
+func (ds *dirstate) dirty(repoPath string, dirstateMtime int32) (bool,
+	error) {
+	if ds.Parent2 != nullHash {
+		// An uncommitted merge.
+		return true, nil
+	}
+	var ambiguous = false
+	for _, entry := range ds.Entries {
+		if entry.State != 'n' {
+			return true, nil
+		}
+		fileInfo, err := os.Lstat(path.Join(repoPath, entry.Name))
+		if err != nil {
+			// A missing file.
+			return true, nil
+		}
+		switch entryChange(entry, fileInfo, dirstateMtime) {
+		case changeModified:
+			return true, nil
+		case changeUnknown:
+			// Keep looking, in case another file is definitely modified.
+			ambiguous = true
+		}
+	}
+	if ambiguous {
+		return false, errAmbiguous
+	}
+	return false, nil
+}
+
+// Whether a file differs from its dirstate entry.
+type change int
+
+const (
+	changeNone change = iota
+	changeModified
+	// Only reading the file's contents would tell.
+	changeUnknown
+)
+
+// Compares a normal dirstate entry with the result of Lstat on its file, in
+// the same way as hg status.
+func entryChange(entry dirstateEntry, fileInfo os.FileInfo,
+	dirstateMtime int32) change {
+	if entry.Size == -2 {
+		// Taken from the other parent of a merge.
+		return changeModified
+	}
+	if entry.Size < 0 || entry.Mtime < 0 {
+		return changeUnknown
+	}
+	var mode = fileInfo.Mode()
+	var wasLink = os.FileMode(entry.Mode)&0170000 == 0120000
+	if wasLink != (mode&os.ModeSymlink != 0) {
+		return changeModified
+	}
+	// Only the owner's execute bit is tracked.
+	if !wasLink && (entry.Mode&0100 != 0) != (mode.Perm()&0100 != 0) {
+		return changeModified
+	}
+	if int32(fileInfo.Size()&0x7fffffff) != entry.Size {
+		return changeModified
+	}
+	var mtime = int32(fileInfo.ModTime().Unix() & 0x7fffffff)
+	if mtime != entry.Mtime || mtime == dirstateMtime {
+		// The contents may or may not have changed.
+		return changeUnknown
+	}
+	return changeNone
+}
@@ -0,0 +1,307 @@
+package hg
+
+import "bytes"
+import "encoding/binary"
+import "encoding/hex"
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+import "testing"
+import "time"
+
+// testdata/dirstate-v1 was encoded by hand, not written by hg, so its parents
+// and mtimes are placeholders.
+func TestParseDirstateFixture(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/dirstate-v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := parseDirstate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Parent1 != strings.Repeat("11", 20) || ds.Parent2 != nullHash {
+		t.Errorf("Unexpected parents %s, %s", ds.Parent1, ds.Parent2)
+	}
+	var expected = []dirstateEntry{
+		{'n', 0100644, 5, 1000, "a.txt", ""},
+		{'a', 0, -1, -1, "new.txt", ""},
+		{'n', 0100755, 12, 2000, "bin/run", ""},
+		{'n', 0120777, 7, 3000, "link", ""},
+		{'n', 0100644, 3, 4000, "copy.txt", "a.txt"},
+		{'r', 0, 0, 0, "gone.txt", ""},
+	}
+	if len(ds.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %v", len(expected), ds.Entries)
+	}
+	for i := range expected {
+		if ds.Entries[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], ds.Entries[i])
+		}
+	}
+}
+
+func TestParseDirstateTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/dirstate-v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, length := range []int{10, 50, len(data) - 1} {
+		if _, err := parseDirstate(data[:length]); err == nil {
+			t.Errorf("Expected an error for %d bytes", length)
+		}
+	}
+}
+
+// Encodes 'ds' in the version 1 dirstate format.
+func encodeDirstate(ds *dirstate) []byte {
+	var buf bytes.Buffer
+	for _, parent := range []string{ds.Parent1, ds.Parent2} {
+		hash, _ := hex.DecodeString(parent)
+		buf.Write(hash)
+	}
+	for _, entry := range ds.Entries {
+		var name = entry.Name
+		if entry.CopySource != "" {
+			name += "\x00" + entry.CopySource
+		}
+		buf.WriteByte(entry.State)
+		binary.Write(&buf, binary.BigEndian, entry.Mode)
+		binary.Write(&buf, binary.BigEndian, entry.Size)
+		binary.Write(&buf, binary.BigEndian, entry.Mtime)
+		binary.Write(&buf, binary.BigEndian, uint32(len(name)))
+		buf.WriteString(name)
+	}
+	return buf.Bytes()
+}
+
+// Checks encodeDirstate against bytes spelled out from Mercurial's own
+// description of the format (mercurial/pure/parsers.py): the two parents,
+// then for each entry struct.pack(">cllll", state, mode, size, mtime,
+// len(name)) followed by the name, with any copy source after a NUL.
+func TestEncodeDirstateLayout(t *testing.T) {
+	var ds = &dirstate{
+		Parent1: strings.Repeat("12", 20),
+		Parent2: nullHash,
+		Entries: []dirstateEntry{
+			{'n', 0100644, 3, 1700000000, "b", "a"},
+			{'a', 0, -1, -1, "c", ""},
+		},
+	}
+	var expected = strings.Repeat("\x12", 20) + strings.Repeat("\x00", 20) +
+		"n" + "\x00\x00\x81\xa4" + "\x00\x00\x00\x03" + "\x65\x53\xf1\x00" +
+		"\x00\x00\x00\x03" + "b\x00a" +
+		"a" + "\x00\x00\x00\x00" + "\xff\xff\xff\xff" + "\xff\xff\xff\xff" +
+		"\x00\x00\x00\x01" + "c"
+	var actual = encodeDirstate(ds)
+	if string(actual) != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+	parsed, err := parseDirstate(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Entries) != 2 || parsed.Entries[0] != ds.Entries[0] {
+		t.Errorf("Unexpected entries %+v", parsed.Entries)
+	}
+}
+
+// Creates a repo with a clean working tree and a dirstate which matches it,
+// with every file's mtime an hour in the past. Returns the repo's root.
+func makeHgRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "hg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files = map[string]os.FileMode{"a.txt": 0644, "bin/run": 0755}
+	var mtime = time.Now().Add(-time.Hour).Truncate(time.Second)
+	var ds = &dirstate{Parent1: strings.Repeat("ab", 20), Parent2: nullHash}
+	for name, perm := range files {
+		var p = path.Join(dir, name)
+		if err = os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var contents = []byte("contents of " + name)
+		if err = ioutil.WriteFile(p, contents, perm); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chmod(p, perm); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		ds.Entries = append(ds.Entries, dirstateEntry{
+			State: 'n',
+			Mode:  0100000 | uint32(perm),
+			Size:  int32(len("contents of " + name)),
+			Mtime: int32(mtime.Unix()),
+			Name:  name,
+		})
+	}
+	writeDirstate(t, dir, ds)
+	return dir
+}
+
+func writeDirstate(t *testing.T, dir string, ds *dirstate) {
+	if err := os.MkdirAll(path.Join(dir, ".hg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var p = path.Join(dir, ".hg", "dirstate")
+	if err := ioutil.WriteFile(p, encodeDirstate(ds), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestDirstate(t *testing.T, dir string) *dirstate {
+	data, err := ioutil.ReadFile(path.Join(dir, ".hg", "dirstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := parseDirstate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestDirstateDirtyClean(t *testing.T) {
+	var dir = makeHgRepo(t)
+	defer os.RemoveAll(dir)
+	dirty, err := dirstateDirty(dir)
+	if dirty || err != nil {
+		t.Errorf("Expected clean, got %v, %v", dirty, err)
+	}
+	// Untracked files don't count.
+	ioutil.WriteFile(path.Join(dir, "untracked"), nil, 0644)
+	dirty, err = dirstateDirty(dir)
+	if dirty || err != nil {
+		t.Errorf("Expected clean, got %v, %v", dirty, err)
+	}
+}
+
+func TestDirstateDirty(t *testing.T) {
+	var cases = []struct {
+		description string
+		modify      func(dir string, ds *dirstate)
+	}{
+		{"resized", func(dir string, ds *dirstate) {
+			ioutil.WriteFile(path.Join(dir, "a.txt"), []byte("changed"), 0644)
+		}},
+		{"missing", func(dir string, ds *dirstate) {
+			os.Remove(path.Join(dir, "bin/run"))
+		}},
+		{"exec bit", func(dir string, ds *dirstate) {
+			os.Chmod(path.Join(dir, "bin/run"), 0644)
+		}},
+		{"added", func(dir string, ds *dirstate) {
+			ds.Entries = append(ds.Entries, dirstateEntry{State: 'a', Size: -1,
+				Mtime: -1, Name: "new.txt"})
+		}},
+		{"removed", func(dir string, ds *dirstate) {
+			ds.Entries[0].State = 'r'
+		}},
+		{"merge", func(dir string, ds *dirstate) {
+			ds.Parent2 = strings.Repeat("cd", 20)
+		}},
+		{"other parent", func(dir string, ds *dirstate) {
+			ds.Entries[0].Size = -2
+		}},
+	}
+	for _, c := range cases {
+		var dir = makeHgRepo(t)
+		var ds = readTestDirstate(t, dir)
+		c.modify(dir, ds)
+		writeDirstate(t, dir, ds)
+		dirty, err := dirstateDirty(dir)
+		if !dirty || err != nil {
+			t.Errorf("%s: expected dirty, got %v, %v", c.description, dirty, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDirstateAmbiguous(t *testing.T) {
+	var cases = []struct {
+		description string
+		modify      func(dir string, ds *dirstate)
+	}{
+		{"same size, new mtime", func(dir string, ds *dirstate) {
+			var p = path.Join(dir, "a.txt")
+			ioutil.WriteFile(p, []byte("CONTENTS OF a.txt"), 0644)
+			var mtime = time.Now().Add(-time.Minute)
+			os.Chtimes(p, mtime, mtime)
+		}},
+		{"unknown size", func(dir string, ds *dirstate) {
+			ds.Entries[0].Size = -1
+		}},
+		{"unknown mtime", func(dir string, ds *dirstate) {
+			ds.Entries[0].Mtime = -1
+		}},
+		{"dirstate v2", func(dir string, ds *dirstate) {
+			ioutil.WriteFile(path.Join(dir, ".hg", "requires"),
+				[]byte("revlogv1\ndirstate-v2\nstore\n"), 0644)
+		}},
+	}
+	for _, c := range cases {
+		var dir = makeHgRepo(t)
+		var ds = readTestDirstate(t, dir)
+		c.modify(dir, ds)
+		writeDirstate(t, dir, ds)
+		if _, err := dirstateDirty(dir); err != errAmbiguous {
+			t.Errorf("%s: expected errAmbiguous, got %v", c.description, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDirstateSameSecond(t *testing.T) {
+	var dir = makeHgRepo(t)
+	defer os.RemoveAll(dir)
+	var ds = readTestDirstate(t, dir)
+	// The dirstate was written in the same second as a.txt was last modified.
+	if _, err := ds.dirty(dir, ds.Entries[0].Mtime); err != errAmbiguous {
+		t.Errorf("Expected errAmbiguous, got %v", err)
+	}
+}
+
+func TestDirstateMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(path.Join(dir, ".hg"), 0755)
+	dirty, err := dirstateDirty(dir)
+	if dirty || err != nil {
+		t.Errorf("Expected clean, got %v, %v", dirty, err)
+	}
+}
+
+func TestDirtyIgnoresUntrackedWhenAmbiguous(t *testing.T) {
+	var dir = makeHgRepo(t)
+	defer os.RemoveAll(dir)
+	var ds = readTestDirstate(t, dir)
+	ds.Entries[0].Mtime = -1
+	writeDirstate(t, dir, ds)
+	ioutil.WriteFile(path.Join(dir, "untracked"), nil, 0644)
+
+	// hg status -q doesn't list untracked files, so it prints nothing.
+	server, err := startFakeServer(t, fakeHello, []fakeExchange{
+		{[]string{"status", "-q"}, []fakeMessage{
+			{channel: 'r', data: resultData(0)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	info, err := GetHgInfoWithServer(dir, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Dirty {
+		t.Errorf("Expected an untracked file not to make the repo dirty")
+	}
+}
@@ -6,6 +6,7 @@
 package hg
 
 import "errors"
+import "fmt"
 import "os"
 import "path"
 import . "github.com/sethpollen/sbp-go-utils/format"
@@ -20,19 +21,51 @@ type HgInfo struct {
 	RepoPath string
 	// Pwd, relative to the root repo path.
 	RelativePwd string
-	// True if there are uncommitted local changes.
+	// True if there are uncommitted changes to tracked files. Untracked files
+	// are never considered.
 	Dirty bool
+	// The branch of the working directory ("default" unless it has been
+	// changed).
+	Branch string
+	// The active bookmark, or "" if there is none.
+	ActiveBookmark string
+	// All bookmarks which point at the working directory's parent.
+	Bookmarks []string
+	// The current topic, from the topic extension, or "" if there is none.
+	Topic string
+	// Hex hash of the working directory's parent, or "" if it can't be read.
+	Parent string
+	// Number of unpublished (draft or secret) changesets among the working
+	// directory parent's ancestors, including the parent itself.
+	Ahead int
+	// True iff we couldn't count Ahead without running hg, so it is not
+	// filled in.
+	AheadUnknown bool
+	// The multi-step operation (rebase, merge, etc.) in progress, if any.
+	Operation Operation
+	// Number of shelves in this repo.
+	ShelveCount int
+	// Root of the repo whose store this one uses (from hg share), or "" if it
+	// has its own store.
+	SharedFrom string
+	// URLs of the default remote, which hg pulls from, and of the remote it
+	// pushes to. Either may be "".
+	DefaultPath     string
+	DefaultPushPath string
+	// URL of the web page of the default remote (or failing that, the push
+	// remote), if it is on a hosting service we recognize. Otherwise "".
+	WebURL string
 }
 
 func GetHgInfo(pwd string) (*HgInfo, error) {
-	repoPath, err := getHgRepoRoot(pwd)
-	if err != nil {
-		return nil, err
-	}
+	return GetHgInfoWithServer(pwd, nil)
+}
 
-	// Now that we know we are in an Hg repo, it's worth paying the cost to run
-	// hg status.
-	status, err := util.EvalCommandSync(pwd, "hg", "status")
+// Like GetHgInfo, but runs any hg commands it needs on 'server' instead of
+// starting new hg processes. 'server' may be nil.
+func GetHgInfoWithServer(pwd string, server *CommandServer) (*HgInfo,
+	error) {
+	repoPath, err := getHgRepoRoot(pwd)
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +74,33 @@ func GetHgInfo(pwd string) (*HgInfo, error) {
 	info.RepoName = path.Base(repoPath)
 	info.RepoPath = repoPath
 	info.RelativePwd = util.RelativePath(pwd, repoPath)
-	info.Dirty = (status != "")
+	info.Branch = readBranch(repoPath)
+	info.ActiveBookmark = readActiveBookmark(repoPath)
+	info.Parent = readWorkingParent(repoPath)
+	info.Bookmarks = bookmarksAt(readBookmarks(repoPath), info.Parent)
+	info.Ahead, err = countUnpublished(repoPath, info.Parent)
+	info.AheadUnknown = err != nil
+	info.Topic = readTopic(repoPath)
+	info.Operation = getOperation(repoPath)
+	info.ShelveCount = countShelves(repoPath)
+	info.SharedFrom = sharedFrom(repoPath)
+	var paths = readPaths(repoPath)
+	info.DefaultPath = paths["default"]
+	info.DefaultPushPath = defaultPushPath(paths)
+	info.WebURL = webURL(info.DefaultPath)
+	if info.WebURL == "" {
+		info.WebURL = webURL(info.DefaultPushPath)
+	}
+	info.Dirty, err = dirstateDirty(repoPath)
+	if err != nil {
+		// Only hg can tell, so it's worth paying the cost to run hg status. -q
+		// hides untracked files, which the dirstate doesn't consider either.
+		status, err := runHg(server, pwd, "status", "-q")
+		if err != nil {
+			return nil, err
+		}
+		info.Dirty = (status != "")
+	}
 	return info, nil
 }
 
@@ -59,22 +118,87 @@ func isHgRepoRoot(pwd string) bool {
 	return err == nil && fileInfo.IsDir()
 }
 
+// Names what the working directory is on: the active bookmark, or otherwise
+// the branch unless it is "default". A topic follows as "//topic", as in
+// Mercurial's fully qualified branch names. Returns "" if there is nothing
+// worth showing.
+func (info *HgInfo) Label() string {
+	var label = info.ActiveBookmark
+	if label == "" && info.Branch != defaultBranch {
+		label = info.Branch
+	}
+	if info.Topic != "" {
+		label += "//" + info.Topic
+	}
+	return label
+}
+
+// Formats an HgInfo as a string, suitable for use as an 'info' string in a
+// prompt.
+func (info *HgInfo) String() string {
+	var str = info.RepoName
+	if label := info.Label(); label != "" {
+		str += ": " + label
+	}
+	if info.Ahead > 0 {
+		str += fmt.Sprintf(" ^%d", info.Ahead)
+	}
+	if info.Dirty {
+		str += " *"
+	}
+	if info.ShelveCount > 0 {
+		str += fmt.Sprintf(" #%d", info.ShelveCount)
+	}
+	return str
+}
+
 // A prompt.Module that matches any directory inside an Hg repo.
 type module struct{}
 
 func (self module) Prepare(env *prompt.PromptEnv) {}
 
 func (self module) Match(env *prompt.PromptEnv, updateCache bool) bool {
-	hgInfo, err := GetHgInfo(env.Pwd)
+	var server *CommandServer
+	if updateCache {
+		// The background pass may need several hg queries, so they share one hg
+		// process. It only starts if something needs hg.
+		if repoPath, err := getHgRepoRoot(env.Pwd); err == nil {
+			server = LazyCommandServer(repoPath)
+			defer server.Close()
+		}
+	}
+	hgInfo, err := GetHgInfoWithServer(env.Pwd, server)
 	if err != nil {
 		return false
 	}
+	if hgInfo.AheadUnknown && env.Memcache != nil {
+		// Counting unpublished changesets in a large repo takes hg, which is too
+		// slow to run inline. The background pass leaves the count in memcache.
+		var ok = false
+		if updateCache {
+			hgInfo.Ahead, err = updateUnpublishedCache(env.Memcache,
+				hgInfo.RepoPath, hgInfo.Parent, server)
+			ok = err == nil
+		} else {
+			hgInfo.Ahead, ok = loadUnpublishedCache(env.Memcache,
+				hgInfo.RepoPath, hgInfo.Parent)
+		}
+		hgInfo.AheadUnknown = !ok
+	}
 
-	env.Info = hgInfo.RepoName
-	if hgInfo.Dirty {
-		env.Info += " *"
+	env.Info = hgInfo.String()
+	var project = util.FindProject(env.Pwd, hgInfo.RepoPath,
+		env.ProjectMarkers)
+	if project != "" {
+		env.Info += " | " + project
 	}
 	env.Flag = append(env.Flag, Stylize("hg", Magenta, Intense)...)
+	if hgInfo.Operation != OpNone {
+		// Make it hard to miss that we are in the middle of something.
+		env.Flag = append(env.Flag, Unstyled(" ")...)
+		env.Flag = append(env.Flag,
+			Stylize(hgInfo.Operation.String(), Yellow, Bold)...)
+	}
 	env.Pwd = hgInfo.RelativePwd
 	return true
 }
@@ -0,0 +1,74 @@
+// Parsing of a repo's .hg/hgrc, for the remote paths it pulls from and pushes
+// to.
+package hg
+
+import "bufio"
+import "os"
+import "path"
+import "strings"
+import "github.com/sethpollen/sbp-go-utils/hosting"
+
+// Reads the [paths] section of the .hg/hgrc file of the repo at 'repoPath',
+// as a map from each path's name to its URL. %include and %unset directives
+// are ignored.
+func readPaths(repoPath string) map[string]string {
+	var paths = make(map[string]string)
+	file, err := os.Open(path.Join(repoPath, ".hg", "hgrc"))
+	if err != nil {
+		return paths
+	}
+	defer file.Close()
+	var section = ""
+	var lastKey = ""
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line = scanner.Text()
+		var trimmed = strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';' ||
+			trimmed[0] == '%':
+			lastKey = ""
+		case line[0] == ' ' || line[0] == '\t':
+			// A continuation of the previous value.
+			if section == "paths" && lastKey != "" {
+				paths[lastKey] = strings.TrimSpace(paths[lastKey] + "\n" + trimmed)
+			}
+		case trimmed[0] == '[':
+			section = strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			lastKey = ""
+		default:
+			var fields = strings.SplitN(trimmed, "=", 2)
+			lastKey = ""
+			if section == "paths" && len(fields) == 2 {
+				lastKey = strings.TrimSpace(fields[0])
+				paths[lastKey] = strings.TrimSpace(fields[1])
+			}
+		}
+	}
+	return paths
+}
+
+// Returns the URL hg pushes to by default, given the repo's [paths]: the
+// "default-push" path, then the "default:pushurl" sub-option, then the
+// "default" path.
+func defaultPushPath(paths map[string]string) string {
+	for _, name := range []string{"default-push", "default:pushurl",
+		"default"} {
+		if url := paths[name]; url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+// Returns the URL of the web page of the hosted repo at 'rawURL', or "" if
+// it isn't a hosted repo. URLs of Git repos used through hg-git have a "git+"
+// scheme prefix.
+func webURL(rawURL string) string {
+	rawURL = strings.TrimPrefix(rawURL, "git+")
+	remote, err := hosting.ParseRemoteURL(rawURL)
+	if err != nil {
+		return ""
+	}
+	return remote.WebURL()
+}
@@ -0,0 +1,78 @@
+package hg
+
+import "os"
+import "testing"
+
+func TestReadPaths(t *testing.T) {
+	var dir = makeHgDir(t, map[string]string{
+		"hgrc": "# Written by hg clone\n" +
+			"[ui]\n" +
+			"default = not a path\n" +
+			"[paths]\n" +
+			"default = ssh://hg@bitbucket.org/owner/repo\n" +
+			"; a comment\n" +
+			"mirror =\n" +
+			"  https://example.com/mirror\n" +
+			"%include other.rc\n" +
+			"[extensions]\n" +
+			"mirror = ignored\n",
+	})
+	defer os.RemoveAll(dir)
+	var paths = readPaths(dir)
+	if paths["default"] != "ssh://hg@bitbucket.org/owner/repo" {
+		t.Errorf("Unexpected default path %q", paths["default"])
+	}
+	if paths["mirror"] != "https://example.com/mirror" {
+		t.Errorf("Unexpected mirror path %q", paths["mirror"])
+	}
+	if len(paths) != 2 {
+		t.Errorf("Expected 2 paths, got %v", paths)
+	}
+}
+
+func TestReadPathsMissing(t *testing.T) {
+	var dir = makeHgDir(t, nil)
+	defer os.RemoveAll(dir)
+	if len(readPaths(dir)) != 0 {
+		t.Errorf("Expected no paths")
+	}
+}
+
+func TestDefaultPushPath(t *testing.T) {
+	var cases = []struct {
+		paths    map[string]string
+		expected string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"default": "a"}, "a"},
+		{map[string]string{"default": "a", "default:pushurl": "b"}, "b"},
+		{map[string]string{"default": "a", "default:pushurl": "b",
+			"default-push": "c"}, "c"},
+	}
+	for _, c := range cases {
+		if actual := defaultPushPath(c.paths); actual != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestWebURL(t *testing.T) {
+	var cases = []struct {
+		rawURL   string
+		expected string
+	}{
+		{"ssh://hg@bitbucket.org/owner/repo",
+			"https://bitbucket.org/owner/repo"},
+		{"https://foss.heptapod.net/group/sub/repo",
+			"https://foss.heptapod.net/group/sub/repo"},
+		{"git+ssh://git@github.com/owner/repo.git",
+			"https://github.com/owner/repo"},
+		{"/home/me/src/repo", ""},
+		{"../repo", ""},
+	}
+	for _, c := range cases {
+		if actual := webURL(c.rawURL); actual != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.rawURL, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Detection of in-progress Mercurial operations, such as a rebase which has
+// stopped on a conflict, and of shelved changes.
+package hg
+
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+
+// A multi-step Hg operation which may be in progress in a working directory.
+type Operation int
+
+const (
+	OpNone Operation = iota
+	OpMerge
+	OpRebase
+	OpHistedit
+	OpGraft
+	OpUnshelve
+	// An update (checkout) which was interrupted.
+	OpUpdate
+)
+
+// Returns a short name for this Operation, or "" for OpNone.
+func (self Operation) String() string {
+	switch self {
+	case OpMerge:
+		return "MERGING"
+	case OpRebase:
+		return "REBASING"
+	case OpHistedit:
+		return "HISTEDITING"
+	case OpGraft:
+		return "GRAFTING"
+	case OpUnshelve:
+		return "UNSHELVING"
+	case OpUpdate:
+		return "UPDATING"
+	}
+	return ""
+}
+
+// The state file which each Operation leaves in .hg, in order of precedence.
+// The merge state is checked last, since the other operations also use it to
+// record conflicts.
+var operationStateFiles = []struct {
+	Operation Operation
+	Name      string
+}{
+	{OpRebase, "rebasestate"},
+	{OpHistedit, "histedit-state"},
+	{OpGraft, "graftstate"},
+	{OpUnshelve, "shelvedstate"},
+	{OpUpdate, "updatestate"},
+	{OpMerge, "merge/state2"},
+	{OpMerge, "merge/state"},
+}
+
+// Inspects the state files in the repo at 'repoPath' to determine which
+// operation, if any, is in progress.
+func getOperation(repoPath string) Operation {
+	for _, stateFile := range operationStateFiles {
+		var p = path.Join(repoPath, ".hg", stateFile.Name)
+		if _, err := os.Stat(p); err == nil {
+			return stateFile.Operation
+		}
+	}
+	return OpNone
+}
+
+// Counts the shelves in the repo at 'repoPath'. Each shelve has a patch file
+// in .hg/shelved, alongside other files with the same base name.
+func countShelves(repoPath string) int {
+	entries, err := ioutil.ReadDir(path.Join(repoPath, ".hg", "shelved"))
+	if err != nil {
+		return 0
+	}
+	var count = 0
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".patch") {
+			count++
+		}
+	}
+	return count
+}
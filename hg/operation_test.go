@@ -0,0 +1,57 @@
+package hg
+
+import "os"
+import "testing"
+
+func TestGetOperation(t *testing.T) {
+	var cases = []struct {
+		files    []string
+		expected Operation
+	}{
+		{nil, OpNone},
+		{[]string{"merge/state2"}, OpMerge},
+		{[]string{"merge/state"}, OpMerge},
+		{[]string{"rebasestate", "merge/state2"}, OpRebase},
+		{[]string{"histedit-state"}, OpHistedit},
+		{[]string{"graftstate", "merge/state"}, OpGraft},
+		{[]string{"shelvedstate"}, OpUnshelve},
+		{[]string{"updatestate"}, OpUpdate},
+	}
+	for _, c := range cases {
+		var files = make(map[string]string)
+		for _, name := range c.files {
+			files[name] = "state"
+		}
+		var dir = makeHgDir(t, files)
+		if actual := getOperation(dir); actual != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.files, c.expected, actual)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCountShelves(t *testing.T) {
+	var dir = makeHgDir(t, map[string]string{
+		"shelved/default.patch":    "",
+		"shelved/default.hg":       "",
+		"shelved/default.shelve":   "",
+		"shelved/default-01.patch": "",
+		"shelved/default-01.hg":    "",
+	})
+	defer os.RemoveAll(dir)
+	if count := countShelves(dir); count != 2 {
+		t.Errorf("Expected 2 shelves, got %d", count)
+	}
+	os.RemoveAll(dir)
+	if count := countShelves(dir); count != 0 {
+		t.Errorf("Expected no shelves, got %d", count)
+	}
+}
+
+func TestStringShelves(t *testing.T) {
+	var info = HgInfo{RepoName: "r", Branch: "default", Dirty: true,
+		ShelveCount: 2}
+	if info.String() != "r * #2" {
+		t.Errorf("Expected \"r * #2\", got \"%s\"", info.String())
+	}
+}
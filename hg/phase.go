@@ -0,0 +1,252 @@
+// Native counting of unpublished (draft or secret) changesets, using the
+// phase roots and the changelog's revlog index.
+package hg
+
+import "bufio"
+import "crypto/sha1"
+import "encoding/binary"
+import "encoding/hex"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+import "github.com/bradfitz/gomemcache/memcache"
+
+// Changelog indexes larger than this are too slow to read on every prompt.
+// Above this size, we rely on counts cached by the --update_cache pass. The
+// cost of reading is in the bytes, not the revisions: a separate index holds
+// 64 bytes per revision, so this allows about 32k revisions, while an inline
+// index also holds the revisions' data, but hg splits it out once it passes
+// 128KiB, so inline indexes never reach this size.
+const maxChangelogIndexBytes = 2 << 20
+
+// Returned when the changelog is too large to read natively.
+var errChangelogTooLarge = errors.New("Changelog index is too large")
+
+// Length of each entry in a version 1 (RevlogNG) revlog index.
+const revlogEntryLength = 64
+
+// Flag in a revlog's header which says that revision data is interleaved
+// with the index entries.
+const revlogInlineFlag = 1 << 16
+
+// The parts of the changelog index we need. Revisions are numbered from 0,
+// and every revision's parents have lower numbers than it does.
+type changelogIndex struct {
+	// Revision numbers of each revision's parents, or -1 for the null
+	// revision.
+	Parents [][2]int32
+	// Maps the hex hashes of the revisions we looked for to their revision
+	// numbers.
+	Revs map[string]int32
+}
+
+// Parses a version 1 revlog index, such as .hg/store/00changelog.i. Only the
+// revisions whose hex hashes are in 'nodes' are added to Revs; comparing raw
+// hashes is much cheaper than hex-encoding every one.
+func parseRevlogIndex(data []byte, nodes []string) (*changelogIndex,
+	error) {
+	var index = &changelogIndex{Revs: make(map[string]int32)}
+	var wanted = make(map[[20]byte]string)
+	for _, node := range nodes {
+		var raw [20]byte
+		if decoded, err := hex.DecodeString(node); err == nil &&
+			len(decoded) == len(raw) {
+			copy(raw[:], decoded)
+			wanted[raw] = node
+		}
+	}
+	if len(data) == 0 {
+		// No revisions yet.
+		return index, nil
+	}
+	if len(data) < 4 {
+		return nil, errors.New("Revlog index is too short")
+	}
+	var header = binary.BigEndian.Uint32(data)
+	if header&0xffff != 1 {
+		return nil, errors.New("Unsupported revlog version")
+	}
+	var inline = header&revlogInlineFlag != 0
+	for pos := 0; pos < len(data); {
+		if pos+revlogEntryLength > len(data) {
+			return nil, errors.New("Truncated revlog entry")
+		}
+		var entry = data[pos : pos+revlogEntryLength]
+		var rev = int32(len(index.Parents))
+		index.Parents = append(index.Parents, [2]int32{
+			int32(binary.BigEndian.Uint32(entry[24:])),
+			int32(binary.BigEndian.Uint32(entry[28:])),
+		})
+		var node [20]byte
+		copy(node[:], entry[32:52])
+		if hexNode, ok := wanted[node]; ok {
+			index.Revs[hexNode] = rev
+		}
+		pos += revlogEntryLength
+		if inline {
+			// Skip the revision's compressed data.
+			pos += int(binary.BigEndian.Uint32(entry[8:]))
+		}
+	}
+	return index, nil
+}
+
+// Reads the phase roots of the repo at 'repoPath': the hex hashes of the
+// changesets at which each non-public phase begins. Every descendant of a
+// root is in that phase or a higher one.
+func readPhaseRoots(repoPath string) []string {
+	file, err := os.Open(path.Join(storePath(repoPath), "phaseroots"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var roots []string
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// Phase 0 is public; everything above it is unpublished.
+		if phase, err := strconv.Atoi(fields[0]); err == nil && phase > 0 {
+			roots = append(roots, fields[1])
+		}
+	}
+	return roots
+}
+
+// Counts the unpublished changesets among the ancestors of 'parent' (which
+// is included), given the changelog index and the phase roots.
+func (index *changelogIndex) countUnpublished(parent string,
+	roots []string) int {
+	head, ok := index.Revs[parent]
+	if !ok || len(roots) == 0 {
+		return 0
+	}
+	// A revision is unpublished iff it is a root or has an unpublished
+	// parent.
+	var unpublished = make([]bool, head+1)
+	for _, root := range roots {
+		if rev, ok := index.Revs[root]; ok && rev <= head {
+			unpublished[rev] = true
+		}
+	}
+	for rev := int32(0); rev <= head; rev++ {
+		for _, p := range index.Parents[rev] {
+			// Parents always come first, unless the index is corrupt.
+			if p >= 0 && p < rev && unpublished[p] {
+				unpublished[rev] = true
+			}
+		}
+	}
+	// Walk back from the head. Ancestors of a public changeset are public, so
+	// we only need to follow unpublished ones.
+	var seen = make(map[int32]bool)
+	var stack = []int32{head}
+	var count = 0
+	for len(stack) > 0 {
+		var rev = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if rev < 0 || rev > head || seen[rev] || !unpublished[rev] {
+			continue
+		}
+		seen[rev] = true
+		count++
+		stack = append(stack, index.Parents[rev][0], index.Parents[rev][1])
+	}
+	return count
+}
+
+// Counts the unpublished ancestors of 'parent' in the repo at 'repoPath',
+// without running hg. Returns errChangelogTooLarge if the changelog is too
+// large to read quickly.
+func countUnpublished(repoPath string, parent string) (int, error) {
+	var roots = readPhaseRoots(repoPath)
+	if len(roots) == 0 {
+		// Everything is public.
+		return 0, nil
+	}
+	var indexPath = path.Join(storePath(repoPath), "00changelog.i")
+	fileInfo, err := os.Stat(indexPath)
+	if err != nil {
+		return 0, err
+	}
+	if fileInfo.Size() > maxChangelogIndexBytes {
+		return 0, errChangelogTooLarge
+	}
+	data, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return 0, err
+	}
+	index, err := parseRevlogIndex(data, append(roots, parent))
+	if err != nil {
+		return 0, err
+	}
+	return index.countUnpublished(parent, roots), nil
+}
+
+// How long to keep unpublished counts in memcache. They are only refreshed by
+// the --update_cache pass, and the stamp check discards stale ones.
+const unpublishedCacheSeconds = 24 * 60 * 60
+
+// Returns the memcache key for the unpublished count of the repo at
+// 'repoPath'.
+func unpublishedCacheKey(repoPath string) string {
+	return fmt.Sprintf("hg-unpublished:%x", sha1.Sum([]byte(repoPath)))
+}
+
+// Builds a string which changes whenever the unpublished count of the repo
+// at 'repoPath' might, given that its working directory's parent is 'parent'.
+func unpublishedStamp(repoPath string, parent string) string {
+	var mtime int64
+	var p = path.Join(storePath(repoPath), "phaseroots")
+	if fileInfo, err := os.Stat(p); err == nil {
+		mtime = fileInfo.ModTime().UnixNano()
+	}
+	return fmt.Sprintf("%s:%d", parent, mtime)
+}
+
+// Counts the unpublished ancestors of the working directory's parent by
+// running hg (on 'server', if it is not nil), and stores the count in
+// memcache. This is slow, so it is only for the --update_cache pass.
+func updateUnpublishedCache(mc *memcache.Client, repoPath string,
+	parent string, server *CommandServer) (int, error) {
+	// Take the stamp first, so that changes made while hg runs will
+	// invalidate what we store.
+	var stamp = unpublishedStamp(repoPath, parent)
+	output, err := runHg(server, repoPath, "log",
+		"-r", "ancestors(.) and not public()", "-T", "x")
+	if err != nil {
+		return 0, err
+	}
+	var count = len(output)
+	mc.Set(&memcache.Item{
+		Key:        unpublishedCacheKey(repoPath),
+		Value:      []byte(fmt.Sprintf("%s %d", stamp, count)),
+		Expiration: unpublishedCacheSeconds,
+	})
+	return count, nil
+}
+
+// Looks up the unpublished count stored by updateUnpublishedCache. The
+// second return value is false if there is no count which is still valid.
+func loadUnpublishedCache(mc *memcache.Client, repoPath string,
+	parent string) (int, bool) {
+	item, err := mc.Get(unpublishedCacheKey(repoPath))
+	if err != nil {
+		return 0, false
+	}
+	var fields = strings.SplitN(string(item.Value), " ", 2)
+	if len(fields) != 2 || fields[0] != unpublishedStamp(repoPath, parent) {
+		return 0, false
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, false
+	}
+	return count, true
+}
@@ -0,0 +1,157 @@
+package hg
+
+import "bytes"
+import "encoding/binary"
+import "encoding/hex"
+import "fmt"
+import "os"
+import "strings"
+import "testing"
+
+// Returns a fake hex hash for revision 'rev'.
+func revHash(rev int) string {
+	return fmt.Sprintf("%040x", rev+1)
+}
+
+// Encodes a version 1 revlog index with one revision per element of
+// 'parents'. If 'inline' is set, each entry is followed by a few bytes of
+// revision data.
+func encodeRevlogIndex(parents [][2]int32, inline bool) []byte {
+	var buf bytes.Buffer
+	for rev, p := range parents {
+		var entry [revlogEntryLength]byte
+		if rev == 0 {
+			var header uint32 = 1
+			if inline {
+				header |= revlogInlineFlag
+			}
+			binary.BigEndian.PutUint32(entry[0:], header)
+		}
+		var data = []byte(fmt.Sprintf("data %d", rev))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[24:], uint32(p[0]))
+		binary.BigEndian.PutUint32(entry[28:], uint32(p[1]))
+		hash, _ := hex.DecodeString(revHash(rev))
+		copy(entry[32:], hash)
+		buf.Write(entry[:])
+		if inline {
+			buf.Write(data)
+		}
+	}
+	return buf.Bytes()
+}
+
+// A history where 2 is a draft root and 4 is a secret root:
+//
+//	0 - 1 - 2 - 3 - 5
+//	     \         /
+//	      4 ------
+var testParents = [][2]int32{
+	{-1, -1}, {0, -1}, {1, -1}, {2, -1}, {1, -1}, {3, 4},
+}
+
+// Returns the hashes of all the revisions in 'parents'.
+func allHashes(parents [][2]int32) []string {
+	var hashes []string
+	for rev := range parents {
+		hashes = append(hashes, revHash(rev))
+	}
+	return hashes
+}
+
+func TestParseRevlogIndex(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		index, err := parseRevlogIndex(encodeRevlogIndex(testParents, inline),
+			allHashes(testParents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Parents) != len(testParents) {
+			t.Fatalf("Expected %d revisions, got %d", len(testParents),
+				len(index.Parents))
+		}
+		for rev := range testParents {
+			if index.Parents[rev] != testParents[rev] {
+				t.Errorf("Rev %d: expected parents %v, got %v", rev,
+					testParents[rev], index.Parents[rev])
+			}
+			if index.Revs[revHash(rev)] != int32(rev) {
+				t.Errorf("Expected %s to be rev %d", revHash(rev), rev)
+			}
+		}
+	}
+}
+
+func TestParseRevlogIndexErrors(t *testing.T) {
+	var data = encodeRevlogIndex(testParents, false)
+	if _, err := parseRevlogIndex(data[:100], nil); err == nil {
+		t.Errorf("Expected an error for a truncated index")
+	}
+	data[3] = 2
+	if _, err := parseRevlogIndex(data, nil); err == nil {
+		t.Errorf("Expected an error for revlog version 2")
+	}
+	index, err := parseRevlogIndex(nil, nil)
+	if err != nil || len(index.Parents) != 0 {
+		t.Errorf("Expected an empty index, got %v, %v", index, err)
+	}
+}
+
+func TestCountUnpublished(t *testing.T) {
+	index, err := parseRevlogIndex(encodeRevlogIndex(testParents, false),
+		allHashes(testParents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots = []string{revHash(2), revHash(4)}
+	var cases = []struct {
+		head     string
+		expected int
+	}{
+		{revHash(0), 0},
+		{revHash(1), 0},
+		{revHash(3), 2},
+		{revHash(4), 1},
+		{revHash(5), 4},
+		{nullHash, 0},
+	}
+	for _, c := range cases {
+		var actual = index.countUnpublished(c.head, roots)
+		if actual != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.head, c.expected, actual)
+		}
+	}
+	if index.countUnpublished(revHash(5), nil) != 0 {
+		t.Errorf("Expected nothing unpublished without roots")
+	}
+}
+
+func TestCountUnpublishedFromFiles(t *testing.T) {
+	var dir = makeHgDir(t, map[string]string{
+		"store/00changelog.i": string(encodeRevlogIndex(testParents, true)),
+		"store/phaseroots": "1 " + revHash(2) + "\n2 " + revHash(4) + "\n" +
+			"malformed\n",
+	})
+	defer os.RemoveAll(dir)
+	count, err := countUnpublished(dir, revHash(5))
+	if count != 4 || err != nil {
+		t.Errorf("Expected 4, got %d, %v", count, err)
+	}
+}
+
+func TestCountUnpublishedAllPublic(t *testing.T) {
+	// Without phase roots, we don't even need the changelog.
+	var dir = makeHgDir(t, nil)
+	defer os.RemoveAll(dir)
+	count, err := countUnpublished(dir, strings.Repeat("ab", 20))
+	if count != 0 || err != nil {
+		t.Errorf("Expected 0, got %d, %v", count, err)
+	}
+}
+
+func TestStringAhead(t *testing.T) {
+	var info = HgInfo{RepoName: "r", Branch: "default", Ahead: 3, Dirty: true}
+	if info.String() != "r ^3 *" {
+		t.Errorf("Expected \"r ^3 *\", got \"%s\"", info.String())
+	}
+}
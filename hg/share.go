@@ -0,0 +1,58 @@
+// Resolution of the store for repos created with "hg share", which keep their
+// history in another repo.
+package hg
+
+import "bufio"
+import "os"
+import "path"
+import "strings"
+
+// Returns the .hg directory of the repo whose store the repo at 'repoPath'
+// shares, or "" if it doesn't share one. .hg/sharedpath holds that path,
+// which is relative to .hg for repos with the relshared requirement.
+func readSharedPath(repoPath string) string {
+	var sharedPath = readFileString(path.Join(repoPath, ".hg", "sharedpath"))
+	if sharedPath == "" {
+		return ""
+	}
+	if !path.IsAbs(sharedPath) {
+		sharedPath = path.Join(repoPath, ".hg", sharedPath)
+	}
+	return path.Clean(sharedPath)
+}
+
+// Returns the root of the repo whose store the repo at 'repoPath' shares, or
+// "" if it doesn't share one.
+func sharedFrom(repoPath string) string {
+	var sharedPath = readSharedPath(repoPath)
+	if sharedPath == "" {
+		return ""
+	}
+	return path.Dir(sharedPath)
+}
+
+// Returns the store directory of the repo at 'repoPath', which holds its
+// history and phases.
+func storePath(repoPath string) string {
+	if sharedPath := readSharedPath(repoPath); sharedPath != "" {
+		return path.Join(sharedPath, "store")
+	}
+	return path.Join(repoPath, ".hg", "store")
+}
+
+// True iff the repo at 'repoPath' shares its bookmarks with the repo whose
+// store it uses (i.e. it was created with "hg share -B").
+func sharesBookmarks(repoPath string) bool {
+	file, err := os.Open(path.Join(repoPath, ".hg", "shared"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "bookmarks" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package hg
+
+import "os"
+import "path"
+import "strings"
+import "testing"
+
+// Creates a repo with a store, and a share of it whose .hg/sharedpath is
+// 'relative' or absolute. Returns the source repo's root and the share's root.
+func makeSharedRepos(t *testing.T, relative bool,
+	shareFiles map[string]string) (string, string) {
+	var source = makeHgDir(t, map[string]string{
+		"bookmarks":           strings.Repeat("ab", 20) + " shared-mark\n",
+		"store/00changelog.i": string(encodeRevlogIndex(testParents, true)),
+		"store/phaseroots":    "1 " + revHash(2) + "\n",
+	})
+	var files = map[string]string{
+		"sharedpath": path.Join(source, ".hg"),
+		"bookmarks":  strings.Repeat("ab", 20) + " local-mark\n",
+	}
+	if relative {
+		files["sharedpath"] = "../../" + path.Base(source) + "/.hg"
+	}
+	for name, contents := range shareFiles {
+		files[name] = contents
+	}
+	return source, makeHgDir(t, files)
+}
+
+func TestSharedStore(t *testing.T) {
+	for _, relative := range []bool{false, true} {
+		source, share := makeSharedRepos(t, relative, nil)
+		if actual := sharedFrom(share); actual != source {
+			t.Errorf("Expected %s, got %s", source, actual)
+		}
+		if actual := storePath(share); actual != path.Join(source, ".hg/store") {
+			t.Errorf("Unexpected store %s", actual)
+		}
+		count, err := countUnpublished(share, revHash(5))
+		if count != 3 || err != nil {
+			t.Errorf("Expected 3, got %d, %v", count, err)
+		}
+		// Bookmarks aren't shared unless the share says so.
+		if _, ok := readBookmarks(share)["local-mark"]; !ok {
+			t.Errorf("Expected the share's own bookmarks, got %v",
+				readBookmarks(share))
+		}
+		os.RemoveAll(source)
+		os.RemoveAll(share)
+	}
+}
+
+func TestSharedBookmarks(t *testing.T) {
+	source, share := makeSharedRepos(t, false,
+		map[string]string{"shared": "bookmarks\n"})
+	defer os.RemoveAll(source)
+	defer os.RemoveAll(share)
+	if _, ok := readBookmarks(share)["shared-mark"]; !ok {
+		t.Errorf("Expected the source's bookmarks, got %v", readBookmarks(share))
+	}
+}
+
+func TestNotShared(t *testing.T) {
+	var dir = makeHgDir(t, nil)
+	defer os.RemoveAll(dir)
+	if sharedFrom(dir) != "" {
+		t.Errorf("Expected no shared repo")
+	}
+	if actual := storePath(dir); actual != path.Join(dir, ".hg/store") {
+		t.Errorf("Unexpected store %s", actual)
+	}
+}
@@ -0,0 +1,138 @@
+// Parsing of remote repo URLs into hosting info and web links. This is shared
+// by the Git and Hg modules.
+package hosting
+
+import "errors"
+import "net/url"
+import "strings"
+
+// The kind of software hosting a remote repo, which determines the layout of
+// its web pages.
+type HostKind int
+
+const (
+	// We don't recognize the host. Links use the GitHub layout, which many
+	// other hosts imitate.
+	HostUnknown HostKind = iota
+	HostGitHub
+	HostGitLab
+	HostGitea
+	HostBitbucket
+)
+
+// Templates for the web pages of a hosted repo. "{base}" is replaced by the
+// repo's web URL, "{branch}" by a branch name and "{commit}" by a commit hash.
+type urlTemplates struct {
+	Branch string
+	Commit string
+}
+
+var hostTemplates = map[HostKind]urlTemplates{
+	HostUnknown:   {"{base}/tree/{branch}", "{base}/commit/{commit}"},
+	HostGitHub:    {"{base}/tree/{branch}", "{base}/commit/{commit}"},
+	HostGitLab:    {"{base}/-/tree/{branch}", "{base}/-/commit/{commit}"},
+	HostGitea:     {"{base}/src/branch/{branch}", "{base}/commit/{commit}"},
+	HostBitbucket: {"{base}/src/{branch}", "{base}/commits/{commit}"},
+}
+
+// Guesses the HostKind from a host name.
+func guessHostKind(host string) HostKind {
+	host = strings.ToLower(host)
+	switch {
+	case strings.Contains(host, "github"):
+		return HostGitHub
+	case strings.Contains(host, "gitlab"):
+		return HostGitLab
+	case strings.Contains(host, "gitea"), strings.Contains(host, "codeberg"):
+		return HostGitea
+	case strings.Contains(host, "bitbucket"):
+		return HostBitbucket
+	}
+	return HostUnknown
+}
+
+// A remote repo on a hosting service.
+type Remote struct {
+	// Name of the remote in the local repo's config (e.g. "origin"), if known.
+	Name string
+	// The remote's URL, after applying any insteadOf rewrites.
+	URL string
+	// Host name, without any user or port.
+	Host string
+	// The owner of the repo. On hosts with nested groups, this may contain
+	// slashes.
+	Owner string
+	// Name of the repo, without any ".git" suffix.
+	Repo string
+	Kind HostKind
+}
+
+// Parses a remote URL in any of the forms Git accepts for network
+// transports: ssh://, git://, http(s):// or scp-style "user@host:path".
+// Returns an error for local paths and for URLs without an owner and repo.
+func ParseRemoteURL(rawURL string) (*Remote, error) {
+	var host, repoPath string
+	if strings.Contains(rawURL, "://") {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.Scheme == "file" || parsed.Hostname() == "" {
+			return nil, errors.New("Not a hosted remote: " + rawURL)
+		}
+		host = parsed.Hostname()
+		repoPath = parsed.Path
+	} else {
+		// scp-style. Git only treats the URL this way if there is a colon before
+		// the first slash.
+		var colon = strings.Index(rawURL, ":")
+		var slash = strings.Index(rawURL, "/")
+		if colon < 0 || (slash >= 0 && slash < colon) {
+			return nil, errors.New("Not a hosted remote: " + rawURL)
+		}
+		host = rawURL[:colon]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		repoPath = rawURL[colon+1:]
+	}
+
+	repoPath = strings.Trim(repoPath, "/")
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	var slash = strings.LastIndex(repoPath, "/")
+	if slash <= 0 || slash == len(repoPath)-1 {
+		return nil, errors.New("No owner and repo in remote: " + rawURL)
+	}
+
+	var remote = new(Remote)
+	remote.URL = rawURL
+	remote.Host = host
+	remote.Owner = repoPath[:slash]
+	remote.Repo = repoPath[slash+1:]
+	remote.Kind = guessHostKind(host)
+	return remote, nil
+}
+
+// Returns the URL of the repo's main web page.
+func (self *Remote) WebURL() string {
+	return "https://" + self.Host + "/" + self.Owner + "/" + self.Repo
+}
+
+// Returns the URL of the web page for 'branch'.
+func (self *Remote) BranchURL(branch string) string {
+	// Escape each component of the branch name, but keep the slashes.
+	var parts = strings.Split(branch, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.NewReplacer(
+		"{base}", self.WebURL(),
+		"{branch}", strings.Join(parts, "/")).
+		Replace(hostTemplates[self.Kind].Branch)
+}
+
+// Returns the URL of the web page for the commit with hash 'commit'.
+func (self *Remote) CommitURL(commit string) string {
+	return strings.NewReplacer("{base}", self.WebURL(), "{commit}", commit).
+		Replace(hostTemplates[self.Kind].Commit)
+}
@@ -0,0 +1,60 @@
+package hosting
+
+import "testing"
+
+func TestParseRemoteURL(t *testing.T) {
+	var cases = []struct {
+		url   string
+		host  string
+		owner string
+		repo  string
+		kind  HostKind
+	}{
+		{"git@github.com:sethpollen/sbp-go-utils.git",
+			"github.com", "sethpollen", "sbp-go-utils", HostGitHub},
+		{"ssh://git@gitlab.example.com:2222/group/sub/proj.git",
+			"gitlab.example.com", "group/sub", "proj", HostGitLab},
+		{"https://user@bitbucket.org/team/repo",
+			"bitbucket.org", "team", "repo", HostBitbucket},
+		{"https://codeberg.org/owner/repo.git/",
+			"codeberg.org", "owner", "repo", HostGitea},
+		{"git://git.example.com/owner/repo",
+			"git.example.com", "owner", "repo", HostUnknown},
+	}
+	for _, c := range cases {
+		remote, err := ParseRemoteURL(c.url)
+		if err != nil {
+			t.Errorf("%s: got an error: %v", c.url, err)
+			continue
+		}
+		if remote.Host != c.host || remote.Owner != c.owner ||
+			remote.Repo != c.repo || remote.Kind != c.kind {
+			t.Errorf("%s: got %+v", c.url, *remote)
+		}
+	}
+}
+
+func TestParseRemoteURLLocal(t *testing.T) {
+	for _, url := range []string{"/src/repo", "../repo", "file:///src/a/b",
+		"github.com:repo"} {
+		if _, err := ParseRemoteURL(url); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestRemoteURLs(t *testing.T) {
+	var remote = &Remote{Host: "gitlab.com", Owner: "g", Repo: "r",
+		Kind: HostGitLab}
+	if remote.WebURL() != "https://gitlab.com/g/r" {
+		t.Errorf("Got \"%s\"", remote.WebURL())
+	}
+	if remote.BranchURL("feature/a b") !=
+		"https://gitlab.com/g/r/-/tree/feature/a%20b" {
+		t.Errorf("Got \"%s\"", remote.BranchURL("feature/a b"))
+	}
+	remote.Kind = HostGitHub
+	if remote.CommitURL("0123") != "https://gitlab.com/g/r/commit/0123" {
+		t.Errorf("Got \"%s\"", remote.CommitURL("0123"))
+	}
+}
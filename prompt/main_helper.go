@@ -6,6 +6,7 @@ package prompt
 import "flag"
 import "fmt"
 import "log"
+import "strings"
 import "time"
 import . "github.com/sethpollen/sbp-go-utils/format"
 import "github.com/sethpollen/sbp-go-utils/util"
@@ -18,6 +19,9 @@ var exitCode = flag.Int("exitcode", 0,
 	"Exit code of previous command. If absent, 0 is assumed.")
 var printTiming = flag.Bool("print_timing", false,
 	"True to log diagnostics about how long each part of the program takes.")
+var projectMarkers = flag.String("project_markers",
+	"go.mod,package.json,Cargo.toml,BUILD,BUILD.bazel,pyproject.toml",
+	"Comma-separated names of files which mark a sub-project within a repo.")
 
 var processStart = time.Now()
 
@@ -46,6 +50,11 @@ func DoMain(modules []Module,
 	LogTime("Begin DoMain")
 
 	var env = NewPromptEnv(*width, *exitCode, util.LocalMemcache())
+	for _, marker := range strings.Split(*projectMarkers, ",") {
+		if marker = strings.TrimSpace(marker); marker != "" {
+			env.ProjectMarkers = append(env.ProjectMarkers, marker)
+		}
+	}
 	for _, module := range modules {
 		LogTime(fmt.Sprintf("Begin Prepare(\"%s\")", module.Description()))
 		module.Prepare(env)
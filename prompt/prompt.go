@@ -34,6 +34,9 @@ type PromptEnv struct {
 	EnvironMod shell.EnvironMod
 	// Handle to the local memcache instance.
 	Memcache *memcache.Client
+	// Names of files which mark the root of a sub-project within a repo, such
+	// as "go.mod".
+	ProjectMarkers []string
 }
 
 // Generates a PromptEnv based on current environment variables. The maximum
@@ -1,9 +1,13 @@
 package util
 
+import "bytes"
 import "errors"
+import "fmt"
+import "os"
 import "os/exec"
 import "path"
 import "strings"
+import "time"
 import "github.com/bradfitz/gomemcache/memcache"
 
 // Gets a connection to the local memcached.
@@ -60,6 +64,44 @@ func EvalCommandSync(pwd string, name string, args ...string) (string, error) {
 	}
 }
 
+// Returned by EvalCmdTimeout when the command doesn't finish in time.
+var ErrTimeout = errors.New("Command timed out")
+
+// Runs 'cmd' and returns its stdout, with surrounding whitespace removed. If
+// 'cmd' has not finished within 'timeout', kills it and returns ErrTimeout
+// without waiting for it to exit. A 'timeout' of zero means no limit.
+func EvalCmdTimeout(cmd *exec.Cmd, timeout time.Duration) (string, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	var done = make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		var timer = time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	case <-deadline:
+		// The goroutine above will reap the process once it dies.
+		cmd.Process.Kill()
+		return "", ErrTimeout
+	}
+}
+
 // Returns the shortest prefix of 'p' for which 'test' returns true. Returns
 // an error if no prefix matched.
 func SearchParents(p string, test func(p string) bool) (string, error) {
@@ -85,6 +127,43 @@ func SearchParents(p string, test func(p string) bool) (string, error) {
 	return "", errors.New("No prefix matched")
 }
 
+// Finds the nearest directory from 'pwd' up to, but not including, 'root'
+// which contains a file named in 'markers' (such as a go.mod marking a
+// sub-project within a monorepo). Returns that directory's path relative to
+// 'root', or "" if there is none or 'pwd' is not under 'root'.
+func FindProject(pwd string, root string, markers []string) string {
+	pwd = path.Clean(pwd)
+	root = path.Clean(root)
+	if !strings.HasPrefix(pwd, root+"/") {
+		return ""
+	}
+	for p := pwd; p != root; p = path.Dir(p) {
+		for _, marker := range markers {
+			if _, err := os.Stat(path.Join(p, marker)); err == nil {
+				return p[len(root)+1:]
+			}
+		}
+	}
+	return ""
+}
+
+// Formats 'd' compactly in its largest whole unit, such as "45s", "12m", "3h"
+// or "5d". Negative durations are treated as zero.
+func ShortDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		if d < 0 {
+			d = 0
+		}
+		return fmt.Sprintf("%ds", int64(d/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int64(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int64(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int64(d/(24*time.Hour)))
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
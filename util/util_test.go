@@ -1,6 +1,11 @@
 package util
 
+import "io/ioutil"
+import "os"
+import "os/exec"
+import "path"
 import "testing"
+import "time"
 
 func TestRelativePathEmpty(t *testing.T) {
 	var r = RelativePath("", "abc")
@@ -62,6 +67,30 @@ func TestEvalCommand(t *testing.T) {
 	}
 }
 
+func TestEvalCmdTimeout(t *testing.T) {
+	output, err := EvalCmdTimeout(exec.Command("echo", "hi"), time.Minute)
+	if err != nil {
+		t.Errorf("Got an error: %v", err)
+	}
+	if output != "hi" {
+		t.Errorf("Expected \"hi\", got \"%s\"", output)
+	}
+
+	var start = time.Now()
+	_, err = EvalCmdTimeout(exec.Command("sleep", "10"), 10*time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Didn't kill the command promptly")
+	}
+
+	_, err = EvalCmdTimeout(exec.Command("false"), 0)
+	if err == nil {
+		t.Error("Expected an error")
+	}
+}
+
 func TestSearchParentsMatchFull(t *testing.T) {
 	match, err := SearchParents("/a/b/c", func(p string) bool { return true })
 	if err != nil {
@@ -131,3 +160,60 @@ func TestGetLongestPrefix(t *testing.T) {
 	}
 }
 
+func TestShortDuration(t *testing.T) {
+	var cases = []struct {
+		d        time.Duration
+		expected string
+	}{
+		{-time.Second, "0s"},
+		{45 * time.Second, "45s"},
+		{12*time.Minute + 30*time.Second, "12m"},
+		{3*time.Hour + 59*time.Minute, "3h"},
+		{5*24*time.Hour + time.Hour, "5d"},
+	}
+	for _, c := range cases {
+		var actual = ShortDuration(c.d)
+		if actual != c.expected {
+			t.Errorf("Expected %s, got %s", c.expected, actual)
+		}
+	}
+}
+
+func TestFindProject(t *testing.T) {
+	root, err := ioutil.TempDir("", "util_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	var auth = path.Join(root, "services", "auth")
+	if err = os.MkdirAll(path.Join(auth, "internal", "db"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"go.mod", "services/auth/go.mod"} {
+		if err = ioutil.WriteFile(path.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var markers = []string{"package.json", "go.mod"}
+	var cases = []struct {
+		pwd      string
+		expected string
+	}{
+		{path.Join(auth, "internal", "db"), "services/auth"},
+		{auth, "services/auth"},
+		// The marker at the root doesn't count.
+		{path.Join(root, "services"), ""},
+		{root, ""},
+		{"/elsewhere", ""},
+	}
+	for _, c := range cases {
+		var actual = FindProject(c.pwd, root, markers)
+		if actual != c.expected {
+			t.Errorf("%s: expected \"%s\", got \"%s\"", c.pwd, c.expected, actual)
+		}
+	}
+	if actual := FindProject(auth, root, nil); actual != "" {
+		t.Errorf("Expected no project without markers, got \"%s\"", actual)
+	}
+}
@@ -0,0 +1,35 @@
+// Prints the URL of the web page for the Git repo containing the current
+// directory, as hosted by its remote.
+package main
+
+import "flag"
+import "fmt"
+import "os"
+import "github.com/sethpollen/sbp-go-utils/git"
+
+var branch = flag.String("branch", "",
+	"If set, print the URL of this branch's page instead.")
+var commit = flag.String("commit", "",
+	"If set, print the URL of the page for the commit with this hash instead.")
+
+func main() {
+	flag.Parse()
+	var pwd = os.Getenv("PWD")
+	if pwd == "" {
+		pwd, _ = os.Getwd()
+	}
+	remote, err := git.GetRemote(pwd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+		return
+	}
+	switch {
+	case *branch != "":
+		fmt.Println(remote.BranchURL(*branch))
+	case *commit != "":
+		fmt.Println(remote.CommitURL(*commit))
+	default:
+		fmt.Println(remote.WebURL())
+	}
+}